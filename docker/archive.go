@@ -0,0 +1,554 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+const (
+	// annotationImageName holds the full image reference in an OCI layout, like containerd does.
+	annotationImageName = "io.containerd.image.name"
+	// annotationRefName holds the tag of the image in an OCI layout.
+	annotationRefName = "org.opencontainers.image.ref.name"
+	// annotationImageIDs holds the comma separated IDs recorded by ExportOCILayout to verify imports.
+	annotationImageIDs = ManagedLabel + ".image.ids"
+	// RecordSuffix is appended to the path of a tarball written by SaveImages for the record verified by LoadImages.
+	RecordSuffix = ".images.json"
+	// maxConfigSize limits the archive entries hashed to find the image configs.
+	maxConfigSize = 1 << 20
+)
+
+// SaveImagesCmd as magelib.Cmd
+func SaveImagesCmd(path string, images ...string) magelib.Cmd {
+	return func() error {
+		return SaveImages(context.Background(), path, images...)
+	}
+}
+
+// SaveImages saves images with `docker save` semantics to a gzip compressed tarball.
+// The digest of the tarball and the IDs of the images are recorded in the file path+RecordSuffix.
+// The tarball is written to a temporary file next to path and only moved to path if the save succeeds.
+//
+// Parameters:
+// - ctx: the context used to cancel the export.
+// - path: the path of the tarball.
+// - images: the image references to save.
+//
+// Returns:
+// - error: an error if an image doesn't exist or writing the tarball fails.
+func SaveImages(ctx context.Context, path string, images ...string) error {
+	logging.Infof("save images %v to %s", images, path)
+
+	cli, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "newClient")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(f, hash))
+	if err := cli.ExportImages(docker.ExportImagesOptions{Names: images, OutputStream: zw, Context: ctx}); err != nil {
+		return errors.Wrap(err, "ExportImages")
+	}
+
+	if err := zw.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+
+	// the digests of the tarball written just now and the IDs of the daemon are the reference for LoadImages
+	ids, err := archiveImageIDs(f.Name())
+	if err != nil {
+		return errors.Wrap(err, "archiveImageIDs")
+	}
+
+	for ref := range ids {
+		image, err := cli.InspectImage(ref)
+		if err != nil {
+			return errors.Wrapf(err, "InspectImage [%s]", ref)
+		}
+
+		if !containsString(ids[ref], image.ID) {
+			ids[ref] = append(ids[ref], image.ID)
+		}
+	}
+
+	record, err := json.MarshalIndent(archiveRecord{Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Images: ids}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent")
+	}
+
+	// a record written before a failed rename doesn't match a previous tarball at path, so it fails to load
+	if err := ioutil.WriteFile(path+RecordSuffix, record, 0644); err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return errors.Wrap(err, "Chmod")
+	}
+
+	return errors.Wrap(os.Rename(f.Name(), path), "Rename")
+}
+
+// archiveRecord is the record of a tarball written by SaveImages.
+type archiveRecord struct {
+	// Digest is the digest of the tarball file.
+	Digest string `json:"digest"`
+	// Images maps the image references to the IDs they may have once loaded.
+	Images map[string][]string `json:"images"`
+}
+
+// LoadImagesCmd as magelib.Cmd
+func LoadImagesCmd(path string) magelib.Cmd {
+	return func() error {
+		_, err := LoadImages(context.Background(), path)
+		return err
+	}
+}
+
+// LoadImages loads the images of a tarball written by SaveImages or `docker save`, compressed or not.
+// The digest of the tarball and the IDs of the loaded images are verified against the record of SaveImages.
+// Tarballs without record are loaded unverified.
+//
+// Parameters:
+// - ctx: the context used to cancel the import.
+// - path: the path of the tarball.
+//
+// Returns:
+// - []string: the loaded image references, sorted.
+// - error: an error if the tarball is invalid, loading fails or a loaded image differs.
+func LoadImages(ctx context.Context, path string) ([]string, error) {
+	expected, err := verifyArchive(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "verifyArchive")
+	}
+
+	r, err := openArchive(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "openArchive")
+	}
+	defer r.Close()
+
+	logging.Infof("load images from %s", path)
+	return loadImages(ctx, r, expected)
+}
+
+// ExportOCILayoutCmd as magelib.Cmd
+func ExportOCILayoutCmd(dir string, images ...string) magelib.Cmd {
+	return func() error {
+		return ExportOCILayout(context.Background(), dir, images...)
+	}
+}
+
+// ExportOCILayout appends the local images to the OCI image layout in dir, which is created if missing.
+// The image references are stored in the `io.containerd.image.name` annotation, the IDs to verify
+// imports with in a ManagedLabel annotation.
+//
+// Parameters:
+// - ctx: the context used to cancel the export.
+// - dir: the layout directory.
+// - images: the tagged image references to export.
+//
+// Returns:
+// - error: an error if an image doesn't exist or writing the layout fails.
+func ExportOCILayout(ctx context.Context, dir string, images ...string) error {
+	tags := make([]name.Tag, 0, len(images))
+	for _, image := range images {
+		tag, err := name.NewTag(image)
+		if err != nil {
+			return errors.Wrapf(err, "NewTag [%s]", image)
+		}
+		tags = append(tags, tag)
+	}
+
+	tmp, err := ioutil.TempFile("", "magelib-save-")
+	if err != nil {
+		return errors.Wrap(err, "TempFile")
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	cli, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "newClient")
+	}
+
+	f, err := os.Create(tmp.Name())
+	if err != nil {
+		return errors.Wrap(err, "Create")
+	}
+
+	err = cli.ExportImages(docker.ExportImagesOptions{Names: images, OutputStream: f, Context: ctx})
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "ExportImages")
+	}
+
+	path, err := layout.FromPath(dir)
+	if err != nil {
+		// an existing but unreadable layout is never overwritten
+		if _, statErr := os.Stat(filepath.Join(dir, "index.json")); !os.IsNotExist(statErr) {
+			return errors.Wrap(err, "FromPath")
+		}
+
+		if path, err = layout.Write(dir, empty.Index); err != nil {
+			return errors.Wrap(err, "Write")
+		}
+	}
+
+	for i, tag := range tags {
+		logging.Infof("export image %s to %s", images[i], dir)
+
+		image, err := tarball.ImageFromPath(tmp.Name(), &tag)
+		if err != nil {
+			return errors.Wrapf(err, "ImageFromPath [%s]", images[i])
+		}
+
+		ids, err := exportedImageIDs(cli, images[i], image)
+		if err != nil {
+			return errors.Wrapf(err, "exportedImageIDs [%s]", images[i])
+		}
+
+		err = path.AppendImage(image, layout.WithAnnotations(map[string]string{
+			annotationImageName: tag.String(),
+			annotationRefName:   tag.TagStr(),
+			annotationImageIDs:  strings.Join(ids, ","),
+		}))
+		if err != nil {
+			return errors.Wrapf(err, "AppendImage [%s]", images[i])
+		}
+	}
+
+	return nil
+}
+
+// ImportOCILayoutCmd as magelib.Cmd
+func ImportOCILayoutCmd(dir string) magelib.Cmd {
+	return func() error {
+		_, err := ImportOCILayout(context.Background(), dir)
+		return err
+	}
+}
+
+// ImportOCILayout loads the images of the OCI image layout in dir with the references of their annotations.
+// The IDs of the loaded images are verified against the IDs recorded by ExportOCILayout.
+// Images of other layouts are loaded unverified.
+//
+// Parameters:
+// - ctx: the context used to cancel the import.
+// - dir: the layout directory.
+//
+// Returns:
+// - []string: the loaded image references, sorted.
+// - error: an error if the layout is invalid, loading fails or a loaded image differs.
+func ImportOCILayout(ctx context.Context, dir string) ([]string, error) {
+	index, err := layout.ImageIndexFromPath(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "ImageIndexFromPath")
+	}
+
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, errors.Wrap(err, "IndexManifest")
+	}
+
+	images := map[name.Reference]v1.Image{}
+	expected := map[string][]string{}
+	for _, desc := range manifest.Manifests {
+		ref := desc.Annotations[annotationImageName]
+		if ref == "" {
+			ref = desc.Annotations[annotationRefName]
+		}
+
+		if ref == "" {
+			logging.Warnf("skip image %s without name in %s", desc.Digest, dir)
+			continue
+		}
+
+		tag, err := name.NewTag(ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewTag [%s]", ref)
+		}
+
+		image, err := index.Image(desc.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Image [%s]", desc.Digest)
+		}
+
+		images[tag] = image
+		expected[tag.String()] = nil
+		if ids := desc.Annotations[annotationImageIDs]; ids != "" {
+			expected[tag.String()] = strings.Split(ids, ",")
+		} else {
+			logging.Warnf("image %s in %s has no recorded IDs and isn't verified", ref, dir)
+		}
+	}
+
+	if len(images) == 0 {
+		return nil, errors.Errorf("no named images in %s", dir)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarball.MultiRefWrite(images, writer))
+	}()
+	defer reader.Close()
+
+	logging.Infof("import images from %s", dir)
+	return loadImages(ctx, reader, expected)
+}
+
+// loadImages loads the tarball r and verifies the IDs of the images against expected, mapping references to
+// the recorded IDs. References without IDs aren't verified. The classic image store uses the config digest as ID,
+// the containerd image store the manifest digest, so both are recorded.
+func loadImages(ctx context.Context, r io.Reader, expected map[string][]string) ([]string, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+
+	var out bytes.Buffer
+	if err := cli.LoadImage(docker.LoadImageOptions{InputStream: r, OutputStream: &out, Context: ctx}); err != nil {
+		return nil, errors.Wrap(err, "LoadImage")
+	}
+
+	if _, err := readJSONStream(&out, os.Stdout); err != nil {
+		return nil, errors.Wrap(err, "load failed")
+	}
+
+	refs := make([]string, 0, len(expected))
+	for ref, ids := range expected {
+		image, err := cli.InspectImage(ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "InspectImage [%s]", ref)
+		}
+
+		if len(ids) > 0 && !containsString(ids, image.ID) {
+			return nil, errors.Errorf("loaded image %s has ID %s, expected %s", ref, image.ID, ids[0])
+		}
+
+		refs = append(refs, ref)
+	}
+
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// archiveImageIDs maps the tags in the manifest.json of a `docker save` tarball to the digests of their configs
+// and, for tarballs in OCI layout, of their manifests.
+func archiveImageIDs(path string) (map[string][]string, error) {
+	r, err := openArchive(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "openArchive")
+	}
+	defer r.Close()
+
+	var manifest tarball.Manifest
+	var index v1.IndexManifest
+	digests := map[string]string{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Next")
+		}
+
+		name := filepath.Clean(header.Name)
+		if name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, errors.Wrap(err, "Decode")
+			}
+			continue
+		}
+
+		if name == "index.json" {
+			if err := json.NewDecoder(tr).Decode(&index); err != nil {
+				return nil, errors.Wrap(err, "Decode")
+			}
+			continue
+		}
+
+		if header.Typeflag != tar.TypeReg || header.Size > maxConfigSize {
+			continue
+		}
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, tr); err != nil {
+			return nil, errors.Wrapf(err, "Copy [%s]", name)
+		}
+		digests[name] = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if manifest == nil {
+		return nil, errors.New("manifest.json not found")
+	}
+
+	ids := map[string][]string{}
+	for _, desc := range manifest {
+		id, ok := digests[filepath.Clean(desc.Config)]
+		if !ok {
+			return nil, errors.Errorf("config %s not found", desc.Config)
+		}
+
+		for _, tag := range desc.RepoTags {
+			ids[tag] = []string{id}
+		}
+	}
+
+	for _, desc := range index.Manifests {
+		if ref, ok := desc.Annotations[annotationImageName]; ok && ids[ref] != nil {
+			ids[ref] = append(ids[ref], desc.Digest.String())
+		}
+	}
+
+	return ids, nil
+}
+
+// verifyArchive checks the digest of the tarball at path against its record and returns the recorded image IDs.
+// Without record the references of the tarball are returned without IDs.
+func verifyArchive(path string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(path + RecordSuffix)
+	if os.IsNotExist(err) {
+		logging.Warnf("%s has no record %s, the images aren't verified", path, RecordSuffix)
+
+		ids, err := archiveImageIDs(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "archiveImageIDs")
+		}
+
+		for ref := range ids {
+			ids[ref] = nil
+		}
+		return ids, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	var record archiveRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, errors.Wrap(err, "Copy")
+	}
+
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != record.Digest {
+		return nil, errors.Errorf("%s has digest %s, recorded %s", path, digest, record.Digest)
+	}
+
+	return record.Images, nil
+}
+
+// exportedImageIDs returns the ID of the exported image in the daemon, its config and manifest digest.
+func exportedImageIDs(cli *docker.Client, ref string, image v1.Image) ([]string, error) {
+	inspected, err := cli.InspectImage(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "InspectImage")
+	}
+
+	config, err := image.ConfigName()
+	if err != nil {
+		return nil, errors.Wrap(err, "ConfigName")
+	}
+
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, errors.Wrap(err, "Digest")
+	}
+
+	ids := []string{inspected.ID}
+	for _, id := range []string{config.String(), digest.String()} {
+		if !containsString(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// openArchive opens the tarball at path and decompresses it if it is gzip compressed.
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, errors.Wrap(err, "Peek")
+	}
+
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "NewReader")
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
@@ -0,0 +1,181 @@
+package docker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// imageDaemon serves the images as fake daemon for save, load and inspect requests.
+// Loaded tarballs are returned, inspect answers with the config digest or the ID in ids.
+func imageDaemon(t *testing.T, images map[string]v1.Image, ids map[string]string) *[]tarball.Manifest {
+	loaded := []tarball.Manifest{}
+
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		switch {
+		case path == "/images/get":
+			refs := map[name.Reference]v1.Image{}
+			for _, names := range r.URL.Query()["names"] {
+				for _, n := range strings.Split(names, ",") {
+					tag, err := name.NewTag(n)
+					require.NoError(t, err)
+					refs[tag] = images[n]
+				}
+			}
+			require.NoError(t, tarball.MultiRefWrite(refs, w))
+		case path == "/images/load":
+			data, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(data)), nil
+			})
+			require.NoError(t, err)
+			loaded = append(loaded, manifest)
+
+			fmt.Fprintln(w, `{"stream":"Loaded image\n"}`)
+		case strings.HasSuffix(path, "/json"):
+			ref := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+			id, ok := ids[ref]
+			if !ok {
+				config, err := images[ref].ConfigName()
+				require.NoError(t, err)
+				id = config.String()
+			}
+			fmt.Fprintf(w, `{"Id":%q}`, id)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	return &loaded
+}
+
+func randomImages(t *testing.T, refs ...string) map[string]v1.Image {
+	images := map[string]v1.Image{}
+	for _, ref := range refs {
+		image, err := random.Image(128, 2)
+		require.NoError(t, err)
+		images[ref] = image
+	}
+	return images
+}
+
+func TestSaveLoadImages(t *testing.T) {
+	images := randomImages(t, "org/app:1.0.0", "registry.local:5000/org/db:2")
+	ids := map[string]string{}
+	loaded := imageDaemon(t, images, ids)
+
+	path := filepath.Join(t.TempDir(), "images.tar.gz")
+	require.NoError(t, SaveImages(context.Background(), path, "org/app:1.0.0", "registry.local:5000/org/db:2"))
+
+	compressed, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	_, err = gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+
+	refs, err := LoadImages(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/app:1.0.0", "registry.local:5000/org/db:2"}, refs)
+	require.Len(t, *loaded, 1)
+	assert.Len(t, (*loaded)[0], 2)
+
+	ids["org/app:1.0.0"] = "sha256:0000"
+	_, err = LoadImages(context.Background(), path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "loaded image org/app:1.0.0 has ID sha256:0000")
+
+	// a tarball without record loads unverified
+	delete(ids, "org/app:1.0.0")
+	other := filepath.Join(t.TempDir(), "other.tar.gz")
+	require.NoError(t, SaveImages(context.Background(), other, "org/app:1.0.0"))
+	require.NoError(t, os.Remove(other+RecordSuffix))
+	ids["org/app:1.0.0"] = "sha256:0000"
+	refs, err = LoadImages(context.Background(), other)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/app:1.0.0"}, refs)
+
+	// a replaced tarball doesn't match the record
+	require.NoError(t, os.Rename(other, path))
+	_, err = LoadImages(context.Background(), path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "recorded sha256:")
+}
+
+func TestSaveImagesKeepsPreviousTarball(t *testing.T) {
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		http.Error(w, "no such image", http.StatusNotFound)
+	})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "images.tar.gz")
+	require.NoError(t, ioutil.WriteFile(path, []byte("previous"), 0644))
+
+	require.Error(t, SaveImages(context.Background(), path, "org/missing:1.0.0"))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(data))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestOCILayout(t *testing.T) {
+	images := randomImages(t, "org/app:1.0.0", "org/app:1.1.0")
+	ids := map[string]string{}
+	loaded := imageDaemon(t, images, ids)
+
+	dir := filepath.Join(t.TempDir(), "layout")
+	require.NoError(t, ExportOCILayout(context.Background(), dir, "org/app:1.0.0"))
+	require.NoError(t, ExportOCILayout(context.Background(), dir, "org/app:1.1.0"))
+
+	refs, err := ImportOCILayout(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"org/app:1.0.0", "org/app:1.1.0"}, refs)
+
+	require.Len(t, *loaded, 1)
+	tags := []string{}
+	for _, desc := range (*loaded)[0] {
+		tags = append(tags, desc.RepoTags...)
+	}
+	assert.ElementsMatch(t, []string{"org/app:1.0.0", "org/app:1.1.0"}, tags)
+
+	_, err = ImportOCILayout(context.Background(), t.TempDir())
+	assert.Error(t, err)
+
+	ids["org/app:1.1.0"] = "sha256:0000"
+	_, err = ImportOCILayout(context.Background(), dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "loaded image org/app:1.1.0 has ID sha256:0000")
+}
+
+func TestExportOCILayoutKeepsUnreadableIndex(t *testing.T) {
+	imageDaemon(t, randomImages(t, "org/app:1.0.0"), map[string]string{})
+
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	require.NoError(t, ioutil.WriteFile(index, []byte("{broken"), 0644))
+
+	assert.Error(t, ExportOCILayout(context.Background(), dir, "org/app:1.0.0"))
+
+	data, err := ioutil.ReadFile(index)
+	require.NoError(t, err)
+	assert.Equal(t, "{broken", string(data))
+}
@@ -0,0 +1,229 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EnvRegistryUser is the environment variable holding the registry user.
+	EnvRegistryUser = "REGISTRY_USER"
+	// EnvRegistryToken is the environment variable holding the registry password or token.
+	EnvRegistryToken = "REGISTRY_TOKEN"
+	// EnvRegistryHost is the registry of the credentials in the environment, e.g. `ghcr.io`.
+	// Keychain ignores the environment credentials if it isn't set.
+	EnvRegistryHost = "REGISTRY_HOST"
+)
+
+// ErrNoCredentials is returned if no credentials are configured for a registry.
+var ErrNoCredentials = errors.New("no registry credentials")
+
+// Keychain resolves the registry credentials from the docker config and its credential helpers,
+// and for REGISTRY_HOST not covered by them from REGISTRY_USER and REGISTRY_TOKEN.
+var Keychain = authn.NewMultiKeychain(authn.DefaultKeychain, envKeychain{})
+
+// Credentials authenticate against a registry.
+type Credentials struct {
+	Registry string
+	Username string
+	Password string
+}
+
+// String formats the credentials with redacted password, so they can be logged.
+func (c Credentials) String() string {
+	password := ""
+	if c.Password != "" {
+		password = ":***"
+	}
+
+	return fmt.Sprintf("%s%s@%s", c.Username, password, c.Registry)
+}
+
+// GoString redacts the password in the %#v format.
+func (c Credentials) GoString() string {
+	return "docker.Credentials{" + c.String() + "}"
+}
+
+// CredentialsFromEnv returns the credentials of REGISTRY_USER and REGISTRY_TOKEN for registry.
+//
+// Parameters:
+// - registry: the registry host. REGISTRY_HOST must match it if set.
+//
+// Returns:
+// - *Credentials: the credentials.
+// - bool: false if the environment holds no credentials for registry.
+func CredentialsFromEnv(registry string) (*Credentials, bool) {
+	user, token := os.Getenv(EnvRegistryUser), os.Getenv(EnvRegistryToken)
+	if user == "" || token == "" {
+		return nil, false
+	}
+
+	if host := os.Getenv(EnvRegistryHost); host != "" && normalizeRegistry(host) != normalizeRegistry(registry) {
+		return nil, false
+	}
+
+	return &Credentials{Registry: registry, Username: user, Password: token}, true
+}
+
+// ResolveCredentials resolves the credentials of registry with Keychain.
+//
+// Parameters:
+// - registry: the registry host, e.g. `ghcr.io` or `index.docker.io`.
+//
+// Returns:
+// - *Credentials: the credentials.
+// - error: ErrNoCredentials if the registry is accessed anonymously, or an error if a credential helper fails.
+func ResolveCredentials(registry string) (*Credentials, error) {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewRegistry")
+	}
+
+	auth, err := Keychain.Resolve(reg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Resolve")
+	}
+
+	config, err := auth.Authorization()
+	if err != nil {
+		return nil, errors.Wrap(err, "Authorization")
+	}
+
+	if config.Username == "" && config.Password == "" {
+		return nil, ErrNoCredentials
+	}
+
+	return &Credentials{Registry: registry, Username: config.Username, Password: config.Password}, nil
+}
+
+// LoginCmd as magelib.Cmd
+func LoginCmd(registry string) magelib.Cmd {
+	return func() error {
+		creds, ok := CredentialsFromEnv(registry)
+		if !ok {
+			return errors.Wrapf(ErrNoCredentials, "%s and %s not set for %s", EnvRegistryUser, EnvRegistryToken, registry)
+		}
+
+		return Login(context.Background(), *creds)
+	}
+}
+
+// Login stores creds with `docker login`, which uses the credential helper of the docker config.
+// The password is passed on stdin and never logged.
+//
+// Parameters:
+// - ctx: the context used to cancel the login.
+// - creds: the credentials to store.
+//
+// Returns:
+// - error: an error if the registry rejects the credentials.
+func Login(ctx context.Context, creds Credentials) error {
+	logging.Infof("login to %s", creds)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "login", "--username", creds.Username, "--password-stdin", creds.Registry)
+	cmd.Stdin = strings.NewReader(creds.Password)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "docker login err: [%s]", redact(strings.TrimSpace(stderr.String()), creds.Password))
+	}
+
+	return nil
+}
+
+// envKeychain resolves the credentials of CredentialsFromEnv for REGISTRY_HOST only,
+// so they are never sent to base image or cache registries.
+type envKeychain struct{}
+
+func (envKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if os.Getenv(EnvRegistryHost) == "" {
+		return authn.Anonymous, nil
+	}
+
+	creds, ok := CredentialsFromEnv(target.RegistryStr())
+	if !ok {
+		return authn.Anonymous, nil
+	}
+
+	return &authn.Basic{Username: creds.Username, Password: creds.Password}, nil
+}
+
+// registryAuth returns the Engine API credentials of the registry of ref, empty ones if none are configured.
+func registryAuth(ref string) (docker.AuthConfiguration, error) {
+	parsed, err := name.ParseReference(ref)
+	if err != nil {
+		return docker.AuthConfiguration{}, errors.Wrap(err, "ParseReference")
+	}
+
+	registry := parsed.Context().RegistryStr()
+	creds, err := ResolveCredentials(registry)
+	if errors.Is(err, ErrNoCredentials) {
+		return docker.AuthConfiguration{}, nil
+	}
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+
+	if registry == name.DefaultRegistry {
+		// the Engine API expects the legacy Docker Hub address
+		registry = "https://index.docker.io/v1/"
+	}
+
+	return docker.AuthConfiguration{
+		Username:      creds.Username,
+		Password:      creds.Password,
+		ServerAddress: registry,
+	}, nil
+}
+
+// authConfigurations returns the Engine API credentials of the registries of refs, used to pull images during builds.
+// Registries without credentials or with invalid references are skipped.
+func authConfigurations(refs []string) docker.AuthConfigurations {
+	configs := map[string]docker.AuthConfiguration{}
+	for _, ref := range refs {
+		auth, err := registryAuth(ref)
+		if err != nil {
+			logging.Warnf("resolve credentials of %s: %v", ref, err)
+			continue
+		}
+
+		if auth.Username != "" {
+			configs[auth.ServerAddress] = auth
+		}
+	}
+
+	return docker.AuthConfigurations{Configs: configs}
+}
+
+// normalizeRegistry maps the Docker Hub aliases to index.docker.io.
+func normalizeRegistry(registry string) string {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return registry
+	}
+
+	return reg.RegistryStr()
+}
+
+// redact replaces the secrets in s.
+func redact(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "***")
+		}
+	}
+
+	return s
+}
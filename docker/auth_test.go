@@ -0,0 +1,147 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsFromEnv(t *testing.T) {
+	t.Setenv(EnvRegistryUser, "")
+	t.Setenv(EnvRegistryToken, "")
+	_, ok := CredentialsFromEnv("ghcr.io")
+	assert.False(t, ok)
+
+	t.Setenv(EnvRegistryUser, "ci")
+	t.Setenv(EnvRegistryToken, "s3cret")
+	creds, ok := CredentialsFromEnv("ghcr.io")
+	require.True(t, ok)
+	assert.Equal(t, Credentials{Registry: "ghcr.io", Username: "ci", Password: "s3cret"}, *creds)
+	assert.Equal(t, "ci:***@ghcr.io", creds.String())
+	assert.NotContains(t, fmt.Sprintf("%v %+v %#v %s", creds, *creds, *creds, creds), "s3cret")
+
+	t.Setenv(EnvRegistryHost, "docker.io")
+	_, ok = CredentialsFromEnv("ghcr.io")
+	assert.False(t, ok)
+	_, ok = CredentialsFromEnv("index.docker.io")
+	assert.True(t, ok)
+}
+
+func TestResolveCredentials(t *testing.T) {
+	t.Setenv(EnvRegistryUser, "")
+	t.Setenv(EnvRegistryToken, "")
+	t.Setenv(EnvRegistryHost, "")
+
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:password"))
+	config := `{"auths":{"registry.example.com":{"auth":"` + auth + `"}}}`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	creds, err := ResolveCredentials("registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", creds.Username)
+	assert.Equal(t, "password", creds.Password)
+
+	_, err = ResolveCredentials("ghcr.io")
+	assert.Equal(t, ErrNoCredentials, err)
+
+	// the environment credentials are only used for REGISTRY_HOST
+	t.Setenv(EnvRegistryUser, "ci")
+	t.Setenv(EnvRegistryToken, "token")
+	_, err = ResolveCredentials("ghcr.io")
+	assert.Equal(t, ErrNoCredentials, err)
+
+	t.Setenv(EnvRegistryHost, "ghcr.io")
+	creds, err = ResolveCredentials("ghcr.io")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", creds.Username)
+
+	_, err = ResolveCredentials("index.docker.io")
+	assert.Equal(t, ErrNoCredentials, err)
+
+	// the docker config takes precedence over the environment
+	t.Setenv(EnvRegistryHost, "registry.example.com")
+	creds, err = ResolveCredentials("registry.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", creds.Username)
+}
+
+func TestRegistryEnvCredentials(t *testing.T) {
+	host := fakeRegistry(t)
+	pushRandomImage(t, host+"/org/app:1.0.0")
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(EnvRegistryUser, "user")
+	t.Setenv(EnvRegistryToken, "password")
+	t.Setenv(EnvRegistryHost, host)
+
+	_, err := RemoteDigest(context.Background(), host+"/org/app:1.0.0")
+	require.NoError(t, err)
+}
+
+func TestLogin(t *testing.T) {
+	bin := t.TempDir()
+	// the fake docker binary records its arguments and stdin and fails for the user `invalid`
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + filepath.Join(bin, "args") + "\n" +
+		"cat > " + filepath.Join(bin, "stdin") + "\n" +
+		"if [ \"$3\" = invalid ]; then echo \"unauthorized: $(cat " + filepath.Join(bin, "stdin") + ")\" >&2; exit 1; fi\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.NoError(t, Login(context.Background(), Credentials{Registry: "ghcr.io", Username: "ci", Password: "s3cret"}))
+
+	args, err := ioutil.ReadFile(filepath.Join(bin, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "login --username ci --password-stdin ghcr.io\n", string(args))
+
+	stdin, err := ioutil.ReadFile(filepath.Join(bin, "stdin"))
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", string(stdin))
+
+	err = Login(context.Background(), Credentials{Registry: "ghcr.io", Username: "invalid", Password: "s3cret"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unauthorized: ***")
+	assert.NotContains(t, err.Error(), "s3cret")
+
+	t.Setenv(EnvRegistryUser, "")
+	assert.ErrorIs(t, LoginCmd("ghcr.io")(), ErrNoCredentials)
+}
+
+func TestPush(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(EnvRegistryUser, "ci")
+	t.Setenv(EnvRegistryToken, "s3cret")
+	t.Setenv(EnvRegistryHost, "registry.example.com:5000")
+
+	var auth map[string]string
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		require.Equal(t, "/images/registry.example.com:5000/org/app/push", path)
+		assert.Equal(t, "1.0.0", r.URL.Query().Get("tag"))
+
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &auth))
+
+		io.WriteString(w, `{"status":"Pushed","id":"1234"}`+"\n")
+		io.WriteString(w, `{"errorDetail":{"message":"denied"},"error":"denied"}`+"\n")
+	})
+
+	err := Push("registry.example.com:5000/org/app:1.0.0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "denied")
+	assert.Equal(t, "ci", auth["username"])
+	assert.Equal(t, "s3cret", auth["password"])
+	assert.Equal(t, "registry.example.com:5000", auth["serveraddress"])
+}
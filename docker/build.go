@@ -0,0 +1,573 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+// successfullyBuilt matches the last message of the legacy builder if no aux message with the image ID is sent.
+var successfullyBuilt = regexp.MustCompile(`Successfully built ([0-9a-f]+)`)
+
+// BuildOptions configure an image build through the Docker Engine API.
+type BuildOptions struct {
+	// ContextDir is the build context. Environment variables are expanded.
+	ContextDir string
+	// Dockerfile is the path of the Dockerfile relative to ContextDir. Defaults to `Dockerfile`.
+	Dockerfile string
+	// Tags are the names of the built image, e.g. `org/app:1.0.0`.
+	Tags      []string
+	BuildArgs magelib.ArgsMap
+	// Target is the stage of a multi-stage Dockerfile to build.
+	Target  string
+	Labels  map[string]string
+	NoCache bool
+	// Pull always attempts to pull newer versions of the base images.
+	Pull bool
+	// CacheFrom are images used as cache sources.
+	CacheFrom []string
+	// Platform is the target platform, e.g. `linux/arm64`.
+	Platform string
+	// Secrets and SSH need BuildKit, builds using them run `docker build` instead of the Engine API.
+	Secrets []BuildSecret
+	SSH     []BuildSSH
+	// Output receives the build progress. Defaults to os.Stdout.
+	Output io.Writer
+}
+
+// BuildSecret is exposed to `RUN --mount=type=secret,id=<ID>` instructions.
+type BuildSecret struct {
+	ID string
+	// Src is the file containing the secret.
+	Src string
+	// Env is the environment variable containing the secret, used if Src is empty.
+	Env string
+}
+
+// BuildSSH forwards an SSH agent socket or keys to `RUN --mount=type=ssh,id=<ID>` instructions.
+type BuildSSH struct {
+	// ID defaults to `default`.
+	ID string
+	// Paths are agent sockets or keys. The SSH_AUTH_SOCK agent is used if empty.
+	Paths []string
+}
+
+// BuildOption configures BuildOptions.
+type BuildOption func(*BuildOptions)
+
+// WithDockerfile sets the Dockerfile path relative to the build context.
+func WithDockerfile(path string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Dockerfile = path
+	}
+}
+
+// WithTags adds names of the built image.
+func WithTags(tags ...string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
+
+// WithBuildArgs adds build args.
+func WithBuildArgs(args magelib.ArgsMap) BuildOption {
+	return func(o *BuildOptions) {
+		if o.BuildArgs == nil {
+			o.BuildArgs = magelib.ArgsMap{}
+		}
+		for key, value := range args {
+			o.BuildArgs[key] = value
+		}
+	}
+}
+
+// WithTarget builds the given stage of a multi-stage Dockerfile.
+func WithTarget(target string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Target = target
+	}
+}
+
+// WithLabel adds an image label.
+func WithLabel(key, value string) BuildOption {
+	return func(o *BuildOptions) {
+		if o.Labels == nil {
+			o.Labels = map[string]string{}
+		}
+		o.Labels[key] = value
+	}
+}
+
+// WithNoCache disables the build cache.
+func WithNoCache() BuildOption {
+	return func(o *BuildOptions) {
+		o.NoCache = true
+	}
+}
+
+// WithPull always pulls newer versions of the base images.
+func WithPull() BuildOption {
+	return func(o *BuildOptions) {
+		o.Pull = true
+	}
+}
+
+// WithCacheFrom adds images used as cache sources.
+func WithCacheFrom(images ...string) BuildOption {
+	return func(o *BuildOptions) {
+		o.CacheFrom = append(o.CacheFrom, images...)
+	}
+}
+
+// WithPlatform sets the target platform, e.g. `linux/arm64`.
+func WithPlatform(platform string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Platform = platform
+	}
+}
+
+// WithSecret exposes the content of the file src as build secret id.
+func WithSecret(id, src string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Secrets = append(o.Secrets, BuildSecret{ID: id, Src: src})
+	}
+}
+
+// WithSecretEnv exposes the environment variable env as build secret id.
+func WithSecretEnv(id, env string) BuildOption {
+	return func(o *BuildOptions) {
+		o.Secrets = append(o.Secrets, BuildSecret{ID: id, Env: env})
+	}
+}
+
+// WithSSH forwards the SSH agent or keys in paths as id.
+func WithSSH(id string, paths ...string) BuildOption {
+	return func(o *BuildOptions) {
+		o.SSH = append(o.SSH, BuildSSH{ID: id, Paths: paths})
+	}
+}
+
+// WithOutput sets the writer receiving the build progress.
+func WithOutput(w io.Writer) BuildOption {
+	return func(o *BuildOptions) {
+		o.Output = w
+	}
+}
+
+// NewBuildOptions returns the options to build the context at contextDir.
+func NewBuildOptions(contextDir string, opts ...BuildOption) BuildOptions {
+	options := BuildOptions{ContextDir: contextDir}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+// BuildImageCmd as magelib.Cmd
+func BuildImageCmd(contextDir string, opts ...BuildOption) magelib.Cmd {
+	return func() error {
+		_, err := BuildImage(context.Background(), NewBuildOptions(contextDir, opts...))
+		return err
+	}
+}
+
+// jsonMessage is a message of the JSON progress stream of the Engine API.
+type jsonMessage struct {
+	Stream      string `json:"stream"`
+	Status      string `json:"status"`
+	ID          string `json:"id"`
+	Error       string `json:"error"`
+	ErrorDetail *struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Aux *struct {
+		ID string `json:"ID"`
+	} `json:"aux"`
+}
+
+// BuildImage builds an image through the Docker Engine API and streams the progress to opts.Output.
+// Builds with secrets or SSH forwarding need BuildKit and run `docker build` instead.
+//
+// Parameters:
+// - ctx: the context used to cancel the build.
+// - opts: the build options, see NewBuildOptions.
+//
+// Returns:
+// - string: the ID of the built image.
+// - error: an error if the build fails, including errors reported in the progress stream.
+func BuildImage(ctx context.Context, opts BuildOptions) (string, error) {
+	contextDir, err := filepath.Abs(os.ExpandEnv(opts.ContextDir))
+	if err != nil {
+		return "", errors.Wrap(err, "Abs")
+	}
+	opts.ContextDir = contextDir
+
+	if filepath.IsAbs(opts.Dockerfile) {
+		if opts.Dockerfile, err = filepath.Rel(contextDir, opts.Dockerfile); err != nil {
+			return "", errors.Wrap(err, "Rel")
+		}
+	}
+
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+
+	logging.Infof("build image %s", strings.Join(opts.Tags, ", "))
+
+	if len(opts.Secrets) > 0 || len(opts.SSH) > 0 {
+		return buildWithCLI(ctx, opts)
+	}
+
+	cli, err := newClient()
+	if err != nil {
+		return "", errors.Wrap(err, "newClient")
+	}
+
+	var name string
+	if len(opts.Tags) > 0 {
+		name = opts.Tags[0]
+	}
+
+	id, err := runJSONStream(opts.Output, func(w io.Writer) error {
+		return cli.BuildImage(docker.BuildImageOptions{
+			Context:        ctx,
+			Name:           name,
+			Dockerfile:     opts.Dockerfile,
+			ContextDir:     contextDir,
+			BuildArgs:      buildArgs(opts.BuildArgs),
+			Target:         opts.Target,
+			Labels:         opts.Labels,
+			NoCache:        opts.NoCache,
+			Pull:           opts.Pull,
+			CacheFrom:      opts.CacheFrom,
+			Platform:       opts.Platform,
+			AuthConfigs:    authConfigurations(buildRefs(opts)),
+			RmTmpContainer: true,
+			OutputStream:   w,
+			RawJSONStream:  true,
+		})
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "build failed")
+	}
+
+	if id == "" {
+		if name == "" {
+			return "", errors.New("build finished without image ID")
+		}
+
+		image, err := cli.InspectImage(name)
+		if err != nil {
+			return "", errors.Wrap(err, "InspectImage")
+		}
+		id = image.ID
+	}
+
+	// the Engine API applies a single tag per build
+	for _, tag := range opts.Tags[min(1, len(opts.Tags)):] {
+		repo, tagName := splitTag(tag)
+		if err := cli.TagImage(id, docker.TagImageOptions{Repo: repo, Tag: tagName, Context: ctx}); err != nil {
+			return "", errors.Wrapf(err, "TagImage [%s]", tag)
+		}
+	}
+
+	return id, nil
+}
+
+// Flags returns the `docker build` flags for the options, without the build context.
+func (o BuildOptions) Flags() []string {
+	args := []string{}
+	if o.Dockerfile != "" {
+		dockerfile := o.Dockerfile
+		if !filepath.IsAbs(dockerfile) {
+			dockerfile = filepath.Join(o.ContextDir, dockerfile)
+		}
+		args = append(args, "--file", dockerfile)
+	}
+	for _, tag := range o.Tags {
+		args = append(args, "--tag", tag)
+	}
+	for _, arg := range buildArgs(o.BuildArgs) {
+		args = append(args, "--build-arg", arg.Name+"="+arg.Value)
+	}
+	if o.Target != "" {
+		args = append(args, "--target", o.Target)
+	}
+	for _, key := range sortedKeys(o.Labels) {
+		args = append(args, "--label", key+"="+o.Labels[key])
+	}
+	if o.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if o.Pull {
+		args = append(args, "--pull")
+	}
+	for _, image := range o.CacheFrom {
+		args = append(args, "--cache-from", image)
+	}
+	if o.Platform != "" {
+		args = append(args, "--platform", o.Platform)
+	}
+	for _, secret := range o.Secrets {
+		spec := "id=" + secret.ID
+		if secret.Src != "" {
+			spec += ",src=" + secret.Src
+		} else if secret.Env != "" {
+			spec += ",env=" + secret.Env
+		}
+		args = append(args, "--secret", spec)
+	}
+	for _, ssh := range o.SSH {
+		spec := ssh.ID
+		if spec == "" {
+			spec = "default"
+		}
+		if len(ssh.Paths) > 0 {
+			spec += "=" + strings.Join(ssh.Paths, ",")
+		}
+		args = append(args, "--ssh", spec)
+	}
+
+	return args
+}
+
+// buildWithCLI builds with BuildKit through `docker build`, the image ID is read from the iidfile.
+func buildWithCLI(ctx context.Context, opts BuildOptions) (string, error) {
+	iidFile, err := ioutil.TempFile("", "magelib-iid-")
+	if err != nil {
+		return "", errors.Wrap(err, "TempFile")
+	}
+	iidFile.Close()
+	defer os.Remove(iidFile.Name())
+
+	args := append([]string{"build", "--iidfile", iidFile.Name()}, opts.Flags()...)
+	args = append(args, opts.ContextDir)
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	cmd.Stdout = opts.Output
+	cmd.Stderr = opts.Output
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, "docker [build]")
+	}
+
+	id, err := ioutil.ReadFile(iidFile.Name())
+	if err != nil {
+		return "", errors.Wrap(err, "ReadFile")
+	}
+
+	return strings.TrimSpace(string(id)), nil
+}
+
+// buildRefs returns the images whose registries are accessed during the build:
+// the base images of the Dockerfile, the cache sources and the tags.
+func buildRefs(opts BuildOptions) []string {
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	refs, err := baseImages(filepath.Join(opts.ContextDir, dockerfile), opts.BuildArgs)
+	if err != nil {
+		logging.Warnf("read base images of %s: %v", dockerfile, err)
+	}
+
+	refs = append(refs, opts.CacheFrom...)
+	return append(refs, opts.Tags...)
+}
+
+// baseImages returns the images of the FROM instructions in the Dockerfile at path.
+// Variables are expanded with the build args and the defaults of the global ARG instructions,
+// stages and scratch are skipped, as are images with unresolved variables.
+func baseImages(path string, args magelib.ArgsMap) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	vars := map[string]string{}
+	stages := map[string]bool{"scratch": true}
+	images := []string{}
+	global := true
+	content := strings.ReplaceAll(string(data), "\\\n", " ")
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if !global {
+				// ARGs after the first FROM are scoped to their stage
+				continue
+			}
+
+			for _, arg := range fields[1:] {
+				kv := strings.SplitN(arg, "=", 2)
+				if value, ok := args[kv[0]]; ok {
+					vars[kv[0]] = value
+				} else if len(kv) == 2 {
+					vars[kv[0]] = strings.Trim(kv[1], `"'`)
+				}
+			}
+		case "FROM":
+			global = false
+			rest := fields[1:]
+			for len(rest) > 0 && strings.HasPrefix(rest[0], "--") {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				continue
+			}
+
+			image := os.Expand(rest[0], func(key string) string {
+				if value, ok := vars[key]; ok {
+					return value
+				}
+				return "$" + key
+			})
+
+			if !stages[strings.ToLower(image)] && !strings.Contains(image, "$") && !containsString(images, image) {
+				images = append(images, image)
+			}
+
+			if len(rest) == 3 && strings.EqualFold(rest[1], "AS") {
+				stages[strings.ToLower(rest[2])] = true
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// runJSONStream calls fn with a writer for the JSON progress stream of the Engine API, which is printed to out.
+// It returns the image ID of the stream and the error of fn or else the first error reported in the stream.
+func runJSONStream(out io.Writer, fn func(w io.Writer) error) (string, error) {
+	reader, writer := io.Pipe()
+	type streamResult struct {
+		id  string
+		err error
+	}
+
+	done := make(chan streamResult, 1)
+	go func() {
+		id, err := readJSONStream(reader, out)
+		done <- streamResult{id, err}
+	}()
+
+	err := fn(writer)
+	writer.Close()
+
+	result := <-done
+	if err != nil {
+		return "", err
+	}
+
+	return result.id, result.err
+}
+
+// readJSONStream writes the progress messages of r to w and returns the image ID reported by the stream
+// and the first error message. r is always drained.
+func readJSONStream(r io.Reader, w io.Writer) (string, error) {
+	var id string
+	var streamErr error
+
+	dec := json.NewDecoder(r)
+	for {
+		var msg jsonMessage
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			io.Copy(ioutil.Discard, r)
+			return "", errors.Wrap(err, "Decode")
+		}
+
+		switch {
+		case msg.Error != "" || msg.ErrorDetail != nil:
+			if streamErr == nil {
+				message := msg.Error
+				if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+					message = msg.ErrorDetail.Message
+				}
+				streamErr = errors.New(message)
+			}
+		case msg.Aux != nil && msg.Aux.ID != "":
+			id = msg.Aux.ID
+		case msg.Stream != "":
+			fmt.Fprint(w, msg.Stream)
+			if m := successfullyBuilt.FindStringSubmatch(msg.Stream); m != nil && id == "" {
+				id = m[1]
+			}
+		case msg.Status != "":
+			if msg.ID != "" {
+				fmt.Fprintf(w, "%s: %s\n", msg.ID, msg.Status)
+			} else {
+				fmt.Fprintln(w, msg.Status)
+			}
+		}
+	}
+
+	return id, streamErr
+}
+
+// buildArgs converts args to Engine API build args sorted by name.
+func buildArgs(args magelib.ArgsMap) []docker.BuildArg {
+	out := []docker.BuildArg{}
+	for key, value := range args {
+		out = append(out, docker.BuildArg{Name: key, Value: value})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// splitTag splits an image reference into repository and tag, `latest` if it has none.
+func splitTag(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+
+	return ref, "latest"
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// newClient connects to the Docker daemon configured by DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH.
+func newClient() (*docker.Client, error) {
+	cli, err := docker.NewClientFromEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "NewClientFromEnv")
+	}
+
+	return cli, nil
+}
@@ -0,0 +1,279 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/denkhaus/magelib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDaemon serves handler as Docker Engine API and points DOCKER_HOST to it.
+// Requests are routed by path without the API version prefix, version and ping requests are answered.
+func fakeDaemon(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, path string)) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/v1.") {
+			path = path[strings.Index(path[1:], "/")+1:]
+		}
+
+		switch path {
+		case "/version":
+			fmt.Fprint(w, `{"ApiVersion":"1.43","Version":"24.0.0"}`)
+		case "/_ping":
+			fmt.Fprint(w, "OK")
+		default:
+			handler(w, r, path)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("DOCKER_HOST", "tcp://"+srv.Listener.Addr().String())
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	t.Setenv("DOCKER_API_VERSION", "")
+}
+
+func writeContext(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestBuildImage(t *testing.T) {
+	var query map[string][]string
+	var files []string
+
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		require.Equal(t, "/build", path)
+		query = r.URL.Query()
+
+		files = nil
+		tr := tar.NewReader(r.Body)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			files = append(files, header.Name)
+		}
+
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]string{"stream": "Step 1/2 : FROM scratch\n"})
+		enc.Encode(map[string]interface{}{"aux": map[string]string{"ID": "sha256:1234"}})
+		enc.Encode(map[string]string{"stream": "Successfully tagged org/app:1.0.0\n"})
+	})
+
+	dir := writeContext(t, map[string]string{
+		"build/app.Dockerfile": "FROM scratch\n",
+		"main.go":              "package main\n",
+	})
+
+	var out bytes.Buffer
+	id, err := BuildImage(context.Background(), BuildOptions{
+		ContextDir: dir,
+		Dockerfile: filepath.Join(dir, "build", "app.Dockerfile"),
+		Tags:       []string{"org/app:1.0.0"},
+		BuildArgs:  magelib.ArgsMap{"VERSION": "1.0.0"},
+		Target:     "release",
+		Labels:     map[string]string{"org.opencontainers.image.version": "1.0.0"},
+		NoCache:    true,
+		Pull:       true,
+		CacheFrom:  []string{"org/app:cache"},
+		Platform:   "linux/arm64",
+		Output:     &out,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:1234", id)
+	assert.Equal(t, "Step 1/2 : FROM scratch\nSuccessfully tagged org/app:1.0.0\n", out.String())
+
+	assert.ElementsMatch(t, []string{"build/", "build/app.Dockerfile", "main.go"}, files)
+	assert.Equal(t, "org/app:1.0.0", query["t"][0])
+	assert.Equal(t, "build/app.Dockerfile", query["dockerfile"][0])
+	assert.Equal(t, "release", query["target"][0])
+	assert.Equal(t, `{"VERSION":"1.0.0"}`, query["buildargs"][0])
+	assert.Equal(t, `{"org.opencontainers.image.version":"1.0.0"}`, query["labels"][0])
+	assert.Equal(t, "1", query["nocache"][0])
+	assert.Equal(t, "1", query["pull"][0])
+	assert.Equal(t, `["org/app:cache"]`, query["cachefrom"][0])
+	assert.Equal(t, "linux/arm64", query["platform"][0])
+}
+
+func TestBuildImageTags(t *testing.T) {
+	tagged := []string{}
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		switch {
+		case path == "/build":
+			io.Copy(ioutil.Discard, r.Body)
+			assert.Equal(t, "org/app:1.0.0", r.URL.Query().Get("t"))
+			fmt.Fprintln(w, `{"aux":{"ID":"sha256:1234"}}`)
+		case path == "/images/sha256:1234/tag":
+			tagged = append(tagged, r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	dir := writeContext(t, map[string]string{"Dockerfile": "FROM scratch AS release\n"})
+	id, err := BuildImage(context.Background(), NewBuildOptions(dir,
+		WithTags("org/app:1.0.0", "org/app:1.0", "registry.local:5000/org/app"),
+		WithTarget("release"),
+		WithOutput(ioutil.Discard),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:1234", id)
+	assert.Equal(t, []string{"org/app:1.0", "registry.local:5000/org/app:latest"}, tagged)
+}
+
+func TestBuildImageAuth(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv(EnvRegistryUser, "ci")
+	t.Setenv(EnvRegistryToken, "s3cret")
+	t.Setenv(EnvRegistryHost, "registry.base.io")
+
+	var configs map[string]map[string]string
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		io.Copy(ioutil.Discard, r.Body)
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Config"))
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &configs))
+		fmt.Fprintln(w, `{"aux":{"ID":"sha256:1234"}}`)
+	})
+
+	dir := writeContext(t, map[string]string{"Dockerfile": "ARG BASE=registry.base.io/org/base:1\nFROM ${BASE} AS build\nFROM build\n"})
+	tags := make([]string, 1, 2)
+	tags[0] = "org/app:1.0.0"
+
+	_, err := BuildImage(context.Background(), BuildOptions{
+		ContextDir: dir,
+		Tags:       tags,
+		CacheFrom:  []string{"org/app:cache"},
+		Output:     ioutil.Discard,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"username": "ci", "password": "s3cret", "serveraddress": "registry.base.io"},
+		configs["registry.base.io"])
+	assert.Len(t, configs, 1)
+	assert.Equal(t, "", tags[:2][1], "the tags of the caller are not modified")
+}
+
+func TestBaseImages(t *testing.T) {
+	dir := writeContext(t, map[string]string{"Dockerfile": `# syntax=docker/dockerfile:1
+ARG GO=1.22 REGISTRY="ghcr.io"
+ARG DISTROLESS
+from --platform=$BUILDPLATFORM golang:${GO} as build
+ARG STAGE=ignored
+FROM $REGISTRY/org/base:1 \
+	AS base
+FROM build AS test
+FROM ${DISTROLESS}/static
+FROM scratch
+FROM golang:${GO}
+`})
+
+	images, err := baseImages(filepath.Join(dir, "Dockerfile"), magelib.ArgsMap{"GO": "1.23"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"golang:1.23", "ghcr.io/org/base:1"}, images)
+
+	_, err = baseImages(filepath.Join(dir, "missing"), nil)
+	assert.Error(t, err)
+}
+
+func TestBuildImageBuildKit(t *testing.T) {
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args")
+	// the fake docker binary records its arguments and writes the image ID to the --iidfile path
+	script := "#!/bin/sh\n" +
+		"printf '%s\\n' \"$@\" > " + argsFile + "\n" +
+		"echo \"buildkit=$DOCKER_BUILDKIT\"\n" +
+		"echo sha256:5678 > \"$3\"\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := writeContext(t, map[string]string{"Dockerfile": "FROM scratch\n"})
+
+	var out bytes.Buffer
+	id, err := BuildImage(context.Background(), NewBuildOptions(dir,
+		WithTags("org/app:1.0.0", "org/app:latest"),
+		WithDockerfile("Dockerfile"),
+		WithLabel("maintainer", "dev"),
+		WithSecret("npmrc", "/home/dev/.npmrc"),
+		WithSecretEnv("token", "GITHUB_TOKEN"),
+		WithSSH(""),
+		WithSSH("github", "/home/dev/.ssh/id_ed25519"),
+		WithOutput(&out),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:5678", id)
+	assert.Equal(t, "buildkit=1\n", out.String())
+
+	args, err := ioutil.ReadFile(argsFile)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	assert.Equal(t, []string{
+		"--file", filepath.Join(dir, "Dockerfile"),
+		"--tag", "org/app:1.0.0",
+		"--tag", "org/app:latest",
+		"--label", "maintainer=dev",
+		"--secret", "id=npmrc,src=/home/dev/.npmrc",
+		"--secret", "id=token,env=GITHUB_TOKEN",
+		"--ssh", "default",
+		"--ssh", "github=/home/dev/.ssh/id_ed25519",
+		dir,
+	}, lines[3:])
+}
+
+func TestBuildImageStreamError(t *testing.T) {
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		io.Copy(ioutil.Discard, r.Body)
+		enc := json.NewEncoder(w)
+		enc.Encode(map[string]string{"stream": "Step 1/2 : RUN false\n"})
+		enc.Encode(map[string]interface{}{
+			"errorDetail": map[string]interface{}{"code": 1, "message": "The command '/bin/sh -c false' returned a non-zero code: 1"},
+			"error":       "The command '/bin/sh -c false' returned a non-zero code: 1",
+		})
+	})
+
+	dir := writeContext(t, map[string]string{"Dockerfile": "FROM scratch\nRUN false\n"})
+	_, err := BuildImage(context.Background(), BuildOptions{ContextDir: dir, Tags: []string{"app"}, Output: ioutil.Discard})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "returned a non-zero code: 1")
+}
+
+func TestBuildImageLegacyStream(t *testing.T) {
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		switch path {
+		case "/build":
+			io.Copy(ioutil.Discard, r.Body)
+			fmt.Fprintln(w, `{"stream":"Successfully built 0123456789ab\n"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	dir := writeContext(t, map[string]string{"Dockerfile": "FROM scratch\n"})
+	require.NoError(t, Build(dir, "app"))
+
+	id, err := BuildImage(context.Background(), BuildOptions{ContextDir: dir, Output: ioutil.Discard})
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789ab", id)
+}
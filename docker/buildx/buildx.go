@@ -0,0 +1,548 @@
+// Package buildx builds multi-platform images with `docker buildx`.
+package buildx
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/docker"
+	"github.com/denkhaus/magelib/git"
+	"github.com/pkg/errors"
+)
+
+// DefaultBuilder is the name of the builder instance used if Options.Builder is empty.
+const DefaultBuilder = "magelib"
+
+// SourceDateEpoch is the build arg BuildKit uses as timestamp of the image, which makes digests reproducible.
+const SourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+// DefaultPlatforms are built if Options.Platforms is empty.
+var DefaultPlatforms = []string{"linux/amd64", "linux/arm64"}
+
+// Options configure a multi-platform build.
+type Options struct {
+	docker.BuildOptions
+	// Builder is the buildx builder instance. Defaults to DefaultBuilder.
+	Builder string
+	// Platforms are the target platforms, e.g. `linux/arm64`. Defaults to DefaultPlatforms.
+	Platforms []string
+	// Push pushes the manifest list to the registries of the tags.
+	Push bool
+	// OCIArchive exports the images as OCI layout tarball to the path if not empty.
+	OCIArchive string
+}
+
+// Option configures Options.
+type Option func(*Options)
+
+// WithBuildOptions applies the single platform build options, e.g. tags and build args.
+func WithBuildOptions(opts ...docker.BuildOption) Option {
+	return func(o *Options) {
+		for _, opt := range opts {
+			opt(&o.BuildOptions)
+		}
+	}
+}
+
+// WithBuilder sets the builder instance.
+func WithBuilder(name string) Option {
+	return func(o *Options) {
+		o.Builder = name
+	}
+}
+
+// WithPlatforms sets the target platforms.
+func WithPlatforms(platforms ...string) Option {
+	return func(o *Options) {
+		o.Platforms = platforms
+	}
+}
+
+// WithPush pushes the manifest list to the registries of the tags.
+func WithPush() Option {
+	return func(o *Options) {
+		o.Push = true
+	}
+}
+
+// WithOCIArchive exports the images as OCI layout tarball to path.
+func WithOCIArchive(path string) Option {
+	return func(o *Options) {
+		o.OCIArchive = path
+	}
+}
+
+// NewOptions returns the options to build the context at contextDir.
+func NewOptions(contextDir string, opts ...Option) Options {
+	options := Options{BuildOptions: docker.BuildOptions{ContextDir: contextDir}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+// Result describes the images of a multi-platform build.
+type Result struct {
+	// Digest is the digest of the manifest list.
+	Digest string
+	// Platforms maps the platforms to the digests of their image manifests.
+	Platforms map[string]string
+}
+
+// platforms returns Platforms, the platform of the single platform options or DefaultPlatforms.
+func (o Options) platforms() []string {
+	if len(o.Platforms) > 0 {
+		return o.Platforms
+	}
+
+	if o.Platform != "" {
+		return []string{o.Platform}
+	}
+
+	return DefaultPlatforms
+}
+
+// EnsureBuilderCmd as magelib.Cmd
+func EnsureBuilderCmd(name string, driverOpts ...string) magelib.Cmd {
+	return func() error {
+		return EnsureBuilder(context.Background(), name, driverOpts...)
+	}
+}
+
+// EnsureBuilder creates the docker-container builder instance name if it doesn't exist.
+//
+// Parameters:
+// - ctx: the context used to cancel the commands.
+// - name: the name of the builder instance. Defaults to DefaultBuilder if empty.
+// - driverOpts: driver options like `network=host`, needed to push to a registry on localhost.
+//
+// Returns:
+// - error: an error if the builder can't be created.
+func EnsureBuilder(ctx context.Context, name string, driverOpts ...string) error {
+	if name == "" {
+		name = DefaultBuilder
+	}
+
+	if _, err := output(ctx, "buildx", "inspect", name); err == nil {
+		return nil
+	}
+
+	logging.Infof("create buildx builder %s", name)
+
+	args := []string{"buildx", "create", "--name", name, "--driver", "docker-container", "--bootstrap"}
+	for _, opt := range driverOpts {
+		args = append(args, "--driver-opt", opt)
+	}
+
+	if _, err := output(ctx, args...); err != nil {
+		return errors.Wrap(err, "buildx [create]")
+	}
+
+	return nil
+}
+
+// BuildCmd as magelib.Cmd
+func BuildCmd(contextDir string, opts ...Option) magelib.Cmd {
+	return func() error {
+		_, err := Build(context.Background(), NewOptions(contextDir, opts...))
+		return err
+	}
+}
+
+// Build builds the images of opts.Platforms with the builder instance, which is created if missing.
+// The images are pushed as manifest list if opts.Push is set and exported if opts.OCIArchive is set.
+//
+// Parameters:
+// - ctx: the context used to cancel the build.
+// - opts: the build options, see NewOptions.
+//
+// Returns:
+// - *Result: the digests of the manifest list and of the image of every platform.
+// - error: an error if the build, push or export fails.
+func Build(ctx context.Context, opts Options) (*Result, error) {
+	if !opts.Push && opts.OCIArchive == "" {
+		return nil, errors.New("build needs Push or OCIArchive")
+	}
+
+	if opts.Push && opts.OCIArchive != "" {
+		return nil, errors.New("build can either Push or export to OCIArchive")
+	}
+
+	if opts.Push && len(opts.Tags) == 0 {
+		return nil, errors.New("push needs a tag")
+	}
+
+	if opts.Builder == "" {
+		opts.Builder = DefaultBuilder
+	}
+
+	opts.Platforms = opts.platforms()
+	opts.Platform = ""
+
+	if opts.Output == nil {
+		opts.Output = os.Stdout
+	}
+
+	contextDir, err := filepath.Abs(os.ExpandEnv(opts.ContextDir))
+	if err != nil {
+		return nil, errors.Wrap(err, "Abs")
+	}
+	opts.ContextDir = contextDir
+
+	if err := EnsureBuilder(ctx, opts.Builder); err != nil {
+		return nil, errors.Wrap(err, "EnsureBuilder")
+	}
+
+	metadataFile, err := ioutil.TempFile("", "magelib-buildx-")
+	if err != nil {
+		return nil, errors.Wrap(err, "TempFile")
+	}
+	metadataFile.Close()
+	defer os.Remove(metadataFile.Name())
+
+	args := []string{
+		"buildx", "build",
+		"--builder", opts.Builder,
+		"--platform", strings.Join(opts.Platforms, ","),
+		"--metadata-file", metadataFile.Name(),
+	}
+
+	if opts.Push {
+		args = append(args, "--push")
+	} else {
+		args = append(args, "--output", "type=oci,dest="+opts.OCIArchive)
+	}
+
+	args = append(args, opts.Flags()...)
+	args = append(args, contextDir)
+
+	logging.Infof("build %s for %s", strings.Join(opts.Tags, ", "), strings.Join(opts.Platforms, ", "))
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = opts.Output
+	cmd.Stderr = opts.Output
+
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "docker [buildx build]")
+	}
+
+	metadata, err := ioutil.ReadFile(metadataFile.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadFile")
+	}
+
+	result := Result{}
+	if err := json.Unmarshal(metadata, &struct {
+		Digest *string `json:"containerimage.digest"`
+	}{&result.Digest}); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	if opts.Push {
+		result.Platforms, err = RemotePlatformDigests(ctx, opts.Tags[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "RemotePlatformDigests")
+		}
+	} else {
+		result.Platforms, err = ArchivePlatformDigests(opts.OCIArchive)
+		if err != nil {
+			return nil, errors.Wrap(err, "ArchivePlatformDigests")
+		}
+	}
+
+	resolvePlatform(result.Platforms, opts.Platforms)
+	return &result, nil
+}
+
+// PushOnDemandCmd as magelib.Cmd
+func PushOnDemandCmd(contextDir string, opts ...Option) magelib.Cmd {
+	return func() error {
+		_, err := PushOnDemand(context.Background(), NewOptions(contextDir, opts...))
+		return err
+	}
+}
+
+// PushOnDemand builds the images and pushes them only if the image digest of a platform differs from the remote one.
+// Reproducible digests need a fixed SOURCE_DATE_EPOCH build arg. If it isn't set in the build args,
+// it defaults to the environment variable or else the commit time of HEAD of the context.
+//
+// Parameters:
+// - ctx: the context used to cancel the builds.
+// - opts: the build options, the first tag is compared to the local build.
+//
+// Returns:
+// - *Result: the digests of the local build if in sync, otherwise of the pushed images.
+// - error: an error if a build or the push fails.
+func PushOnDemand(ctx context.Context, opts Options) (*Result, error) {
+	if len(opts.Tags) == 0 {
+		return nil, errors.New("push needs a tag")
+	}
+
+	dir, err := ioutil.TempDir("", "magelib-buildx-")
+	if err != nil {
+		return nil, errors.Wrap(err, "TempDir")
+	}
+	defer os.RemoveAll(dir)
+
+	if _, ok := opts.BuildArgs[SourceDateEpoch]; !ok {
+		epoch, err := sourceDateEpoch(opts.ContextDir)
+		if err != nil {
+			logging.Warnf("build without %s, the digests of unchanged images differ: %v", SourceDateEpoch, err)
+		} else {
+			args := magelib.ArgsMap{SourceDateEpoch: epoch}
+			for key, value := range opts.BuildArgs {
+				args[key] = value
+			}
+			opts.BuildArgs = args
+		}
+	}
+
+	local := opts
+	local.Push = false
+	local.OCIArchive = filepath.Join(dir, "image.tar")
+
+	result, err := Build(ctx, local)
+	if err != nil {
+		return nil, errors.Wrap(err, "Build")
+	}
+
+	remote, err := RemotePlatformDigests(ctx, opts.Tags[0])
+	if err == nil {
+		resolvePlatform(remote, opts.platforms())
+	}
+
+	if err != nil {
+		logging.Infof("push %s, the remote image can't be inspected: %v", opts.Tags[0], err)
+	} else if reflect.DeepEqual(result.Platforms, remote) {
+		logging.Infof("remote image %s is in sync with local version", opts.Tags[0])
+		return result, nil
+	} else {
+		for _, platform := range sortedPlatforms(result.Platforms, remote) {
+			if result.Platforms[platform] != remote[platform] {
+				logging.Infof("push %s, %s differs: local [%s] remote [%s]",
+					opts.Tags[0], platform, result.Platforms[platform], remote[platform])
+			}
+		}
+	}
+
+	// the second build is served from the cache of the builder
+	opts.Push = true
+	opts.OCIArchive = ""
+
+	return Build(ctx, opts)
+}
+
+// sourceDateEpoch returns SOURCE_DATE_EPOCH of the environment or else the commit time of HEAD of the repository at dir.
+func sourceDateEpoch(dir string) (string, error) {
+	if epoch := os.Getenv(SourceDateEpoch); epoch != "" {
+		return epoch, nil
+	}
+
+	commitTime, err := git.Repo(os.ExpandEnv(dir)).CommitTime("HEAD")
+	if err != nil {
+		return "", errors.Wrap(err, "CommitTime")
+	}
+
+	return strconv.FormatInt(commitTime.Unix(), 10), nil
+}
+
+// sortedPlatforms returns the platforms of all digest maps, sorted.
+func sortedPlatforms(digests ...map[string]string) []string {
+	found := map[string]bool{}
+	platforms := []string{}
+	for _, m := range digests {
+		for platform := range m {
+			if !found[platform] {
+				found[platform] = true
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+
+	sort.Strings(platforms)
+	return platforms
+}
+
+// RemotePlatformDigests returns the image digest of every platform of the manifest list ref in a registry.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+// - ref: the image reference, e.g. `org/app:1.0.0`.
+//
+// Returns:
+// - map[string]string: the image manifest digests by platform.
+// - error: an error if ref can't be inspected.
+func RemotePlatformDigests(ctx context.Context, ref string) (map[string]string, error) {
+	out, err := output(ctx, "buildx", "imagetools", "inspect", "--raw", ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "buildx [imagetools inspect]")
+	}
+
+	return platformDigests([]byte(out), nil)
+}
+
+// ArchivePlatformDigests returns the image digest of every platform in an OCI layout tarball.
+//
+// Parameters:
+// - archive: the path of the tarball.
+//
+// Returns:
+// - map[string]string: the image manifest digests by platform.
+// - error: an error if the tarball isn't a valid OCI layout.
+func ArchivePlatformDigests(archive string) (map[string]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Next")
+		}
+
+		name := path.Clean(header.Name)
+		if name != "index.json" && !strings.HasPrefix(name, "blobs/") {
+			continue
+		}
+
+		// layers are skipped, only the index and manifests are needed
+		if header.Size > 1<<20 {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "ReadAll [%s]", name)
+		}
+		files[name] = data
+	}
+
+	index, ok := files["index.json"]
+	if !ok {
+		return nil, errors.New("index.json not found")
+	}
+
+	return platformDigests(index, func(digest string) ([]byte, error) {
+		blob, ok := files["blobs/"+strings.Replace(digest, ":", "/", 1)]
+		if !ok {
+			return nil, errors.Errorf("blob %s not found", digest)
+		}
+		return blob, nil
+	})
+}
+
+const (
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerIndex = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+type imageIndex struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// platformDigests maps the platforms of an image index to their manifest digests.
+// Nested indexes, like the one of an OCI layout, are resolved with blob, attestation manifests are skipped.
+// A manifest without platform is mapped to the empty platform.
+func platformDigests(data []byte, blob func(digest string) ([]byte, error)) (map[string]string, error) {
+	index := imageIndex{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "Unmarshal")
+	}
+
+	digests := map[string]string{}
+	for _, m := range index.Manifests {
+		if m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerIndex {
+			if blob == nil {
+				return nil, errors.Errorf("nested index %s", m.Digest)
+			}
+
+			nested, err := blob(m.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			platforms, err := platformDigests(nested, blob)
+			if err != nil {
+				return nil, err
+			}
+
+			for platform, digest := range platforms {
+				digests[platform] = digest
+			}
+			continue
+		}
+
+		if m.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+			continue
+		}
+
+		if m.Platform == nil {
+			// single platform builds may reference the image manifest without platform
+			digests[""] = m.Digest
+			continue
+		}
+
+		platform := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" {
+			platform += "/" + m.Platform.Variant
+		}
+		digests[platform] = m.Digest
+	}
+
+	return digests, nil
+}
+
+// resolvePlatform maps the digest of the empty platform to the only platform of a single platform build.
+func resolvePlatform(digests map[string]string, platforms []string) {
+	if digest, ok := digests[""]; ok && len(platforms) == 1 {
+		delete(digests, "")
+		digests[platforms[0]] = digest
+	}
+}
+
+func output(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "docker err: [%s]", strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
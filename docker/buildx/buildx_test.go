@@ -0,0 +1,173 @@
+package buildx
+
+import (
+	"archive/tar"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	index = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:amd64","platform":{"os":"linux","architecture":"amd64"}},` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:arm64","platform":{"os":"linux","architecture":"arm64","variant":"v8"}},` +
+		`{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:att","platform":{"os":"unknown","architecture":"unknown"},` +
+		`"annotations":{"vnd.docker.reference.type":"attestation-manifest"}}]}`
+	layout = `{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"sha256:index"}]}`
+)
+
+// fakeDocker puts a docker script on PATH which logs its arguments, serves remoteIndex for
+// `imagetools inspect` and writes the metadata file and the OCI archive of `buildx build`.
+func fakeDocker(t *testing.T, remoteIndex string) string {
+	bin := t.TempDir()
+	archive := writeArchive(t, map[string]string{
+		"oci-layout":         `{"imageLayoutVersion":"1.0.0"}`,
+		"index.json":         layout,
+		"blobs/sha256/index": index,
+		"blobs/sha256/layer": strings.Repeat("x", 1<<21),
+	})
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "index.json"), []byte(remoteIndex), 0644))
+	script := `#!/bin/sh
+echo "$@" >> ` + filepath.Join(bin, "log") + `
+case "$1 $2" in
+"buildx inspect") exit 1 ;;
+"buildx imagetools") cat ` + filepath.Join(bin, "index.json") + ` ;;
+"buildx build")
+	while [ $# -gt 0 ]; do
+		case "$1" in
+		--metadata-file) printf '{"containerimage.digest":"sha256:index"}' > "$2" ;;
+		--output) cp ` + archive + ` "${2#type=oci,dest=}" ;;
+		esac
+		shift
+	done ;;
+esac
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return filepath.Join(bin, "log")
+}
+
+func writeArchive(t *testing.T, files map[string]string) string {
+	path := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	return path
+}
+
+func readLog(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestBuild(t *testing.T) {
+	log := fakeDocker(t, index)
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "app.tar")
+
+	result, err := Build(context.Background(), NewOptions(dir,
+		WithBuildOptions(docker.WithTags("org/app:1.0.0"), docker.WithTarget("release"), docker.WithOutput(ioutil.Discard)),
+		WithOCIArchive(archive),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, &Result{
+		Digest:    "sha256:index",
+		Platforms: map[string]string{"linux/amd64": "sha256:amd64", "linux/arm64/v8": "sha256:arm64"},
+	}, result)
+
+	lines := readLog(t, log)
+	require.Len(t, lines, 3)
+	assert.Equal(t, "buildx inspect magelib", lines[0])
+	assert.Equal(t, "buildx create --name magelib --driver docker-container --bootstrap", lines[1])
+	assert.Contains(t, lines[2], "buildx build --builder magelib --platform linux/amd64,linux/arm64 --metadata-file ")
+	assert.True(t, strings.HasSuffix(lines[2], " --output type=oci,dest="+archive+" --tag org/app:1.0.0 --target release "+dir), lines[2])
+
+	result, err = Build(context.Background(), NewOptions(dir,
+		WithBuildOptions(docker.WithTags("org/app:1.0.0"), docker.WithOutput(ioutil.Discard)),
+		WithPlatforms("linux/amd64", "linux/arm64/v8"),
+		WithBuilder("ci"),
+		WithPush(),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:amd64", result.Platforms["linux/amd64"])
+
+	lines = readLog(t, log)
+	assert.Contains(t, lines[5], "buildx build --builder ci --platform linux/amd64,linux/arm64/v8 ")
+	assert.Contains(t, lines[5], " --push ")
+	assert.Equal(t, "buildx imagetools inspect --raw org/app:1.0.0", lines[6])
+
+	_, err = Build(context.Background(), NewOptions(dir))
+	assert.Error(t, err)
+}
+
+func TestPushOnDemand(t *testing.T) {
+	dir := t.TempDir()
+	git := exec.Command("git", "init", "-q")
+	git.Dir = dir
+	require.NoError(t, git.Run())
+	git = exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "-q", "--allow-empty", "-m", "initial commit")
+	git.Dir = dir
+	git.Env = append(os.Environ(), "GIT_COMMITTER_DATE=1600000000 +0000")
+	require.NoError(t, git.Run())
+
+	opts := NewOptions(dir, WithBuildOptions(docker.WithTags("org/app:1.0.0"), docker.WithOutput(ioutil.Discard)))
+
+	log := fakeDocker(t, index)
+	_, err := PushOnDemand(context.Background(), opts)
+	require.NoError(t, err)
+	assert.NotContains(t, strings.Join(readLog(t, log), "\n"), "--push")
+	assert.Contains(t, readLog(t, log)[2], " --build-arg SOURCE_DATE_EPOCH=1600000000 ")
+	assert.Nil(t, opts.BuildArgs)
+
+	opts = NewOptions(dir, WithBuildOptions(
+		docker.WithTags("org/app:1.0.0"),
+		docker.WithBuildArgs(magelib.ArgsMap{SourceDateEpoch: "1"}),
+		docker.WithOutput(ioutil.Discard),
+	))
+	log = fakeDocker(t, index)
+	_, err = PushOnDemand(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Contains(t, readLog(t, log)[2], " --build-arg SOURCE_DATE_EPOCH=1 ")
+
+	log = fakeDocker(t, strings.Replace(index, "sha256:arm64", "sha256:old", 1))
+	result, err := PushOnDemand(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(readLog(t, log), "\n"), "--push")
+	assert.Equal(t, "sha256:index", result.Digest)
+}
+
+func TestPlatformDigestsSinglePlatform(t *testing.T) {
+	archive := writeArchive(t, map[string]string{
+		"index.json": `{"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:amd64"}]}`,
+	})
+
+	digests, err := ArchivePlatformDigests(archive)
+	require.NoError(t, err)
+	resolvePlatform(digests, []string{"linux/amd64"})
+	assert.Equal(t, map[string]string{"linux/amd64": "sha256:amd64"}, digests)
+
+	_, err = ArchivePlatformDigests(writeArchive(t, map[string]string{"index.json": layout}))
+	assert.EqualError(t, err, "blob sha256:index not found")
+}
@@ -0,0 +1,82 @@
+package buildx
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegistryIntegration builds and pushes to a registry:2 container. It needs a docker daemon with buildx.
+func TestRegistryIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	for _, args := range [][]string{{"info"}, {"buildx", "version"}} {
+		if err := exec.Command("docker", args...).Run(); err != nil {
+			t.Skipf("docker %v not available: %v", args, err)
+		}
+	}
+
+	ctx := context.Background()
+	registry, err := docker.RunContainer(ctx, docker.RunOptions{
+		Image:   "registry:2",
+		Ports:   []string{"5000"},
+		WaitFor: []docker.WaitFor{docker.ForHTTP("5000", "/v2/")},
+	})
+	require.NoError(t, err)
+	defer registry.Remove(ctx)
+
+	addr, err := registry.HostPort(ctx, "5000")
+	require.NoError(t, err)
+
+	// the builder container reaches the registry published on localhost through the host network
+	builder := "magelib-test"
+	require.NoError(t, EnsureBuilder(ctx, builder, "network=host"))
+	defer exec.Command("docker", "buildx", "rm", builder).Run()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\nCOPY hello.txt /\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0644))
+
+	tag := addr + "/magelib/app:test"
+	opts := NewOptions(dir,
+		WithBuilder(builder),
+		WithBuildOptions(
+			docker.WithTags(tag),
+			docker.WithBuildArgs(magelib.ArgsMap{SourceDateEpoch: "1600000000"}),
+			docker.WithOutput(ioutil.Discard),
+		),
+	)
+
+	pushed := opts
+	pushed.Push = true
+	result, err := Build(ctx, pushed)
+	require.NoError(t, err)
+	assert.Len(t, result.Platforms, 2)
+
+	remote, err := RemotePlatformDigests(ctx, tag)
+	require.NoError(t, err)
+	assert.Equal(t, result.Platforms, remote)
+
+	// an unchanged reproducible build is in sync with the registry
+	synced, err := PushOnDemand(ctx, opts)
+	require.NoError(t, err)
+	assert.Equal(t, result.Platforms, synced.Platforms)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("changed\n"), 0644))
+	changed, err := PushOnDemand(ctx, opts)
+	require.NoError(t, err)
+	assert.NotEqual(t, result.Platforms, changed.Platforms)
+
+	remote, err = RemotePlatformDigests(ctx, tag)
+	require.NoError(t, err)
+	assert.Equal(t, changed.Platforms, remote)
+}
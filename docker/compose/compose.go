@@ -0,0 +1,451 @@
+// Package compose manages multi-container applications with `docker compose` (Compose v2).
+package compose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/docker"
+	"github.com/pkg/errors"
+)
+
+// Project is a compose project, the flags are passed to every `docker compose` command.
+type Project struct {
+	// Name is the project name. Compose uses the name of the project directory if empty.
+	Name string
+	// Files are the compose files, later files override earlier ones. Compose looks for compose.yaml if empty.
+	Files []string
+	// Profiles are the enabled profiles.
+	Profiles []string
+	// EnvFile is the file of the variables to interpolate the compose files with.
+	EnvFile string
+	// ProjectDir is the working directory of the project. Defaults to the directory of the first file.
+	ProjectDir string
+	// Output receives the progress of up and down. Defaults to os.Stdout.
+	Output io.Writer
+}
+
+// Option configures a Project.
+type Option func(*Project)
+
+// WithName sets the project name.
+func WithName(name string) Option {
+	return func(p *Project) {
+		p.Name = name
+	}
+}
+
+// WithFiles sets the compose files.
+func WithFiles(files ...string) Option {
+	return func(p *Project) {
+		p.Files = files
+	}
+}
+
+// WithProfiles enables the profiles.
+func WithProfiles(profiles ...string) Option {
+	return func(p *Project) {
+		p.Profiles = profiles
+	}
+}
+
+// WithEnvFile sets the file of the interpolation variables.
+func WithEnvFile(path string) Option {
+	return func(p *Project) {
+		p.EnvFile = path
+	}
+}
+
+// WithProjectDir sets the working directory of the project.
+func WithProjectDir(dir string) Option {
+	return func(p *Project) {
+		p.ProjectDir = dir
+	}
+}
+
+// WithOutput sets the writer of the progress output.
+func WithOutput(w io.Writer) Option {
+	return func(p *Project) {
+		p.Output = w
+	}
+}
+
+// NewProject returns the project configured by opts.
+func NewProject(opts ...Option) Project {
+	project := Project{}
+	for _, opt := range opts {
+		opt(&project)
+	}
+
+	return project
+}
+
+// UpOptions configure Up.
+type UpOptions struct {
+	// Services are the services to start. All services of the enabled profiles are started if empty.
+	Services []string
+	// Build builds the images before starting the containers.
+	Build bool
+	// Wait waits until the services are running or healthy.
+	Wait bool
+	// WaitTimeout limits Wait if not zero, rounded up to whole seconds.
+	WaitTimeout time.Duration
+	// RemoveOrphans removes containers of services no longer defined in the files.
+	RemoveOrphans bool
+}
+
+// DownOptions configure Down.
+type DownOptions struct {
+	// Volumes removes the named volumes of the project and the anonymous volumes of its containers.
+	Volumes bool
+	// RemoveOrphans removes containers of services no longer defined in the files.
+	RemoveOrphans bool
+}
+
+// Container is the state of a service container as reported by Ps.
+type Container struct {
+	ID         string
+	Name       string
+	Image      string
+	Project    string
+	Service    string
+	State      string
+	Health     string
+	ExitCode   int
+	Publishers []Publisher
+}
+
+// Publisher is a port published by a service container.
+type Publisher struct {
+	URL           string
+	TargetPort    int
+	PublishedPort int
+	Protocol      string
+}
+
+// Flags returns the global flags of the project.
+func (p Project) Flags() []string {
+	flags := []string{}
+	if p.Name != "" {
+		flags = append(flags, "--project-name", p.Name)
+	}
+
+	for _, file := range p.Files {
+		flags = append(flags, "--file", file)
+	}
+
+	for _, profile := range p.Profiles {
+		flags = append(flags, "--profile", profile)
+	}
+
+	if p.EnvFile != "" {
+		flags = append(flags, "--env-file", p.EnvFile)
+	}
+
+	if p.ProjectDir != "" {
+		flags = append(flags, "--project-directory", p.ProjectDir)
+	}
+
+	return flags
+}
+
+// UpCmd as magelib.Cmd
+func (p Project) UpCmd(opts UpOptions) magelib.Cmd {
+	return func() error {
+		return p.Up(context.Background(), opts)
+	}
+}
+
+// Up creates and starts the containers of the project in the background.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+// - opts: the services to start and whether to build and wait for them.
+//
+// Returns:
+// - error: an error if a container fails to start or, with opts.Wait, to become healthy.
+func (p Project) Up(ctx context.Context, opts UpOptions) error {
+	args := []string{"up", "--detach"}
+	if opts.Build {
+		args = append(args, "--build")
+	}
+
+	if opts.Wait {
+		args = append(args, "--wait")
+		if opts.WaitTimeout > 0 {
+			args = append(args, "--wait-timeout", strconv.Itoa(waitSeconds(opts.WaitTimeout)))
+		}
+	}
+
+	if opts.RemoveOrphans {
+		args = append(args, "--remove-orphans")
+	}
+
+	logging.Infof("compose up %s", p.describe())
+	return p.run(ctx, append(args, opts.Services...)...)
+}
+
+// DownCmd as magelib.Cmd
+func (p Project) DownCmd(opts DownOptions) magelib.Cmd {
+	return func() error {
+		return p.Down(context.Background(), opts)
+	}
+}
+
+// Down stops and removes the containers and networks of the project.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+// - opts: whether volumes and orphaned containers are removed too.
+//
+// Returns:
+// - error: an error if the project can't be removed.
+func (p Project) Down(ctx context.Context, opts DownOptions) error {
+	args := []string{"down"}
+	if opts.Volumes {
+		args = append(args, "--volumes")
+	}
+
+	if opts.RemoveOrphans {
+		args = append(args, "--remove-orphans")
+	}
+
+	logging.Infof("compose down %s", p.describe())
+	return p.run(ctx, args...)
+}
+
+// PsCmd as magelib.Cmd
+func (p Project) PsCmd(services ...string) magelib.Cmd {
+	return func() error {
+		containers, err := p.Ps(context.Background(), services...)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range containers {
+			logging.Infof("%s: %s %s", c.Name, c.State, c.Health)
+		}
+
+		return nil
+	}
+}
+
+// Ps lists the containers of the project including stopped ones.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+// - services: the services to list. All services are listed if empty.
+//
+// Returns:
+// - []Container: the containers of the services.
+// - error: an error if the command fails or its output can't be parsed.
+func (p Project) Ps(ctx context.Context, services ...string) ([]Container, error) {
+	out, err := p.output(ctx, append([]string{"ps", "--all", "--format", "json"}, services...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "docker [compose ps]")
+	}
+
+	containers, err := parsePs(out)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsePs")
+	}
+
+	return containers, nil
+}
+
+// LogsCmd as magelib.Cmd
+func (p Project) LogsCmd(services ...string) magelib.Cmd {
+	return func() error {
+		logs, err := p.Logs(context.Background(), services...)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(p.writer(), logs)
+		return err
+	}
+}
+
+// Logs returns the logs of the services, each line prefixed with its container.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+// - services: the services whose logs are returned. The logs of all services are returned if empty.
+//
+// Returns:
+// - string: the stdout and stderr logs.
+// - error: an error if the command fails.
+func (p Project) Logs(ctx context.Context, services ...string) (string, error) {
+	out, err := p.output(ctx, append([]string{"logs", "--no-color"}, services...)...)
+	if err != nil {
+		return "", errors.Wrap(err, "docker [compose logs]")
+	}
+
+	return out, nil
+}
+
+// ExecCmd as magelib.Cmd, it fails if cmd exits with a non-zero exit code.
+func (p Project) ExecCmd(service string, cmd ...string) magelib.Cmd {
+	return func() error {
+		result, err := p.Exec(context.Background(), service, cmd...)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(p.writer(), result.Stdout); err != nil {
+			return errors.Wrap(err, "write [stdout]")
+		}
+
+		if result.ExitCode != 0 {
+			return errors.Errorf("%s exited with code %d: %s", strings.Join(cmd, " "), result.ExitCode, strings.TrimSpace(result.Stderr))
+		}
+
+		return nil
+	}
+}
+
+// Exec runs cmd in the running container of service without a TTY.
+// A non-zero exit code is no error, check ExecResult.ExitCode.
+func (p Project) Exec(ctx context.Context, service string, cmd ...string) (*docker.ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	command := p.command(ctx, append([]string{"exec", "--no-TTY", service}, cmd...)...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	result := &docker.ExecResult{}
+	if err := command.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, errors.Wrap(err, "docker [compose exec]")
+		}
+		result.ExitCode = exitErr.ExitCode()
+	}
+
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+// ConfigCmd as magelib.Cmd, it validates the compose files.
+func (p Project) ConfigCmd() magelib.Cmd {
+	return func() error {
+		_, err := p.Config(context.Background())
+		return err
+	}
+}
+
+// Config validates the compose files and returns the resolved configuration.
+//
+// Parameters:
+// - ctx: the context used to cancel the command.
+//
+// Returns:
+// - string: the merged and interpolated configuration as YAML.
+// - error: an error describing why the configuration is invalid.
+func (p Project) Config(ctx context.Context) (string, error) {
+	out, err := p.output(ctx, "config")
+	if err != nil {
+		return "", errors.Wrap(err, "docker [compose config]")
+	}
+
+	return out, nil
+}
+
+// parsePs parses the output of `ps --format json`, which is a JSON array before Compose v2.21
+// and a JSON object per line since.
+func parsePs(out string) ([]Container, error) {
+	containers := []Container{}
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return containers, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "Decode")
+		}
+
+		if bytes.HasPrefix(raw, []byte("[")) {
+			list := []Container{}
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, errors.Wrap(err, "Unmarshal")
+			}
+			containers = append(containers, list...)
+			continue
+		}
+
+		var c Container
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, errors.Wrap(err, "Unmarshal")
+		}
+		containers = append(containers, c)
+	}
+}
+
+// waitSeconds rounds timeout up to whole seconds, `--wait-timeout 0` wouldn't limit the wait.
+func waitSeconds(timeout time.Duration) int {
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// describe names the project in log messages.
+func (p Project) describe() string {
+	if p.Name != "" {
+		return p.Name
+	}
+
+	if len(p.Files) > 0 {
+		return strings.Join(p.Files, ", ")
+	}
+
+	return "project"
+}
+
+// writer returns the project output.
+func (p Project) writer() io.Writer {
+	if p.Output != nil {
+		return p.Output
+	}
+
+	return os.Stdout
+}
+
+// command returns the `docker compose` command of args with the project flags.
+func (p Project) command(ctx context.Context, args ...string) *exec.Cmd {
+	args = append(append([]string{"compose"}, p.Flags()...), args...)
+	return exec.CommandContext(ctx, "docker", args...)
+}
+
+// run runs the command of args and prints its output to the project output.
+func (p Project) run(ctx context.Context, args ...string) error {
+	cmd := p.command(ctx, args...)
+	cmd.Stdout = p.writer()
+	cmd.Stderr = p.writer()
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "docker [compose %s]", args[0])
+	}
+
+	return nil
+}
+
+// output runs the command of args and returns its stdout.
+func (p Project) output(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := p.command(ctx, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "docker err: [%s]", strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,143 @@
+package compose
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denkhaus/magelib/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	psLines = `{"ID":"c1","Name":"app-web-1","Image":"nginx:1.27","Project":"app","Service":"web","State":"running","Health":"healthy","ExitCode":0,` +
+		`"Publishers":[{"URL":"127.0.0.1","TargetPort":80,"PublishedPort":8080,"Protocol":"tcp"}]}
+{"ID":"c2","Name":"app-migrate-1","Image":"app:1.0.0","Project":"app","Service":"migrate","State":"exited","Health":"","ExitCode":0,"Publishers":null}
+`
+	psArray = `[{"ID":"c1","Name":"app-web-1","Service":"web","State":"running"}]`
+)
+
+// fakeDocker puts a docker script on PATH which logs its arguments and answers the compose
+// commands. ps prints the content of the file ps.json next to the log.
+func fakeDocker(t *testing.T, ps string) string {
+	bin := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "ps.json"), []byte(ps), 0644))
+
+	script := `#!/bin/sh
+echo "$@" >> ` + filepath.Join(bin, "log") + `
+for arg in "$@"; do
+	case "$arg" in
+	up|down) echo "$arg done"; exit 0 ;;
+	ps) cat ` + filepath.Join(bin, "ps.json") + `; exit 0 ;;
+	logs) echo "web-1  | ready"; exit 0 ;;
+	exec) echo out; echo err >&2; exit 3 ;;
+	config) echo "services: {}"; exit 0 ;;
+	esac
+done
+echo "unknown command" >&2
+exit 1
+`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return filepath.Join(bin, "log")
+}
+
+func readLog(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestProject(t *testing.T) {
+	log := fakeDocker(t, psLines)
+	ctx := context.Background()
+
+	var out strings.Builder
+	p := NewProject(
+		WithName("app"),
+		WithFiles("compose.yaml", "compose.ci.yaml"),
+		WithProfiles("tools"),
+		WithEnvFile(".env.ci"),
+		WithOutput(&out),
+	)
+	flags := "compose --project-name app --file compose.yaml --file compose.ci.yaml --profile tools --env-file .env.ci"
+
+	require.NoError(t, p.Up(ctx, UpOptions{Services: []string{"web"}, Build: true, Wait: true, WaitTimeout: 90 * time.Second}))
+	require.NoError(t, p.Down(ctx, DownOptions{Volumes: true, RemoveOrphans: true}))
+	assert.Equal(t, "up done\ndown done\n", out.String())
+
+	containers, err := p.Ps(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Container{
+		{
+			ID: "c1", Name: "app-web-1", Image: "nginx:1.27", Project: "app", Service: "web", State: "running", Health: "healthy",
+			Publishers: []Publisher{{URL: "127.0.0.1", TargetPort: 80, PublishedPort: 8080, Protocol: "tcp"}},
+		},
+		{ID: "c2", Name: "app-migrate-1", Image: "app:1.0.0", Project: "app", Service: "migrate", State: "exited"},
+	}, containers)
+
+	logs, err := p.Logs(ctx, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "web-1  | ready\n", logs)
+
+	result, err := p.Exec(ctx, "web", "nginx", "-t")
+	require.NoError(t, err)
+	assert.Equal(t, &docker.ExecResult{ExitCode: 3, Stdout: "out\n", Stderr: "err\n"}, result)
+	assert.EqualError(t, p.ExecCmd("web", "nginx", "-t")(), "nginx -t exited with code 3: err")
+
+	config, err := p.Config(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "services: {}\n", config)
+
+	assert.Equal(t, []string{
+		flags + " up --detach --build --wait --wait-timeout 90 web",
+		flags + " down --volumes --remove-orphans",
+		flags + " ps --all --format json",
+		flags + " logs --no-color web",
+		flags + " exec --no-TTY web nginx -t",
+		flags + " exec --no-TTY web nginx -t",
+		flags + " config",
+	}, readLog(t, log))
+}
+
+func TestWaitSeconds(t *testing.T) {
+	assert.Equal(t, 1, waitSeconds(200*time.Millisecond))
+	assert.Equal(t, 1, waitSeconds(time.Second))
+	assert.Equal(t, 2, waitSeconds(1500*time.Millisecond))
+	assert.Equal(t, 90, waitSeconds(90*time.Second))
+}
+
+func TestPsArray(t *testing.T) {
+	fakeDocker(t, psArray)
+
+	containers, err := NewProject().Ps(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Container{{ID: "c1", Name: "app-web-1", Service: "web", State: "running"}}, containers)
+
+	fakeDocker(t, "")
+	containers, err = NewProject().Ps(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, containers)
+
+	fakeDocker(t, "{")
+	_, err = NewProject().Ps(context.Background())
+	assert.Error(t, err)
+}
+
+func TestCommandError(t *testing.T) {
+	fakeDocker(t, "")
+
+	err := NewProject().run(context.Background(), "pull")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker [compose pull]")
+
+	_, err = NewProject().output(context.Background(), "pull")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker err: [unknown command]")
+}
@@ -0,0 +1,611 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+)
+
+// ManagedLabel is added to all containers started by RunContainer.
+const ManagedLabel = "io.github.denkhaus.magelib"
+
+// healthy is the health status of a container whose healthcheck passes.
+const healthy = "healthy"
+
+// pollInterval is the interval between readiness checks while waiting for a container.
+var pollInterval = 500 * time.Millisecond
+
+// Mount mounts a host path or a named volume into a container.
+type Mount struct {
+	// Type is `bind` or `volume`. Defaults to `bind`.
+	Type string
+	// Source is the host path of a bind mount or the name of a volume.
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// RunOptions configure a container started by RunContainer.
+type RunOptions struct {
+	Image string
+	// Name of the container. Docker generates a name if empty.
+	Name string
+	Cmd  []string
+	Env  map[string]string
+	// Ports are container ports like `5432` or `53/udp`, published on random host ports of 127.0.0.1.
+	Ports  []string
+	Mounts []Mount
+	// Labels are added to the container and the volumes created for its mounts, e.g. to remove them with Cleanup.
+	Labels map[string]string
+	// HealthCheck overrides the healthcheck of the image, e.g. `CMD-SHELL pg_isready`.
+	HealthCheck *docker.HealthConfig
+	// WaitHealthy waits until the healthcheck of the container reports healthy.
+	WaitHealthy bool
+	// WaitFor are strategies waited for after the container started, e.g. ForPort.
+	WaitFor []WaitFor
+}
+
+// Container is a handle of a container started by RunContainer.
+type Container struct {
+	ID   string
+	Name string
+	cli  *docker.Client
+}
+
+// ExecResult is the result of a command executed in a container.
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// RunContainer pulls the image if missing, creates and starts a container.
+// The container and the volumes created for its mounts are labelled with ManagedLabel, so Cleanup finds them.
+//
+// Parameters:
+// - ctx: the context used to cancel starting and waiting for the container.
+// - opts: the container configuration.
+//
+// Returns:
+// - *Container: the handle of the started container.
+// - error: an error if the container can't be started or doesn't become healthy. A created container is removed then.
+func RunContainer(ctx context.Context, opts RunOptions) (*Container, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+
+	if _, err := cli.InspectImage(opts.Image); err != nil {
+		if err := pullImage(ctx, cli, opts.Image); err != nil {
+			return nil, errors.Wrap(err, "pullImage")
+		}
+	}
+
+	labels := map[string]string{ManagedLabel: "true"}
+	for key, value := range opts.Labels {
+		labels[key] = value
+	}
+
+	env := make([]string, 0, len(opts.Env))
+	for _, key := range sortedKeys(opts.Env) {
+		env = append(env, key+"="+opts.Env[key])
+	}
+
+	exposed := map[docker.Port]struct{}{}
+	bindings := map[docker.Port][]docker.PortBinding{}
+	for _, port := range opts.Ports {
+		p := containerPort(port)
+		exposed[p] = struct{}{}
+		// an empty host port publishes on a random port
+		bindings[p] = []docker.PortBinding{{HostIP: "127.0.0.1"}}
+	}
+
+	mounts := make([]docker.HostMount, 0, len(opts.Mounts))
+	for _, m := range opts.Mounts {
+		mountType := m.Type
+		if mountType == "" {
+			mountType = "bind"
+		}
+		mount := docker.HostMount{Type: mountType, Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}
+		if mountType == "volume" {
+			// the labels apply if the daemon creates the volume, existing volumes keep theirs
+			mount.VolumeOptions = &docker.VolumeOptions{Labels: labels}
+		}
+		mounts = append(mounts, mount)
+	}
+
+	logging.Infof("run container %s from %s", opts.Name, opts.Image)
+
+	created, err := cli.CreateContainer(docker.CreateContainerOptions{
+		Name: opts.Name,
+		Config: &docker.Config{
+			Image:        opts.Image,
+			Cmd:          opts.Cmd,
+			Env:          env,
+			Labels:       labels,
+			ExposedPorts: exposed,
+			Healthcheck:  opts.HealthCheck,
+		},
+		HostConfig: &docker.HostConfig{
+			PortBindings: bindings,
+			Mounts:       mounts,
+		},
+		Context: ctx,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateContainer")
+	}
+
+	c := &Container{ID: created.ID, Name: strings.TrimPrefix(created.Name, "/"), cli: cli}
+	if c.Name == "" {
+		c.Name = opts.Name
+	}
+
+	if err := c.start(ctx, opts); err != nil {
+		if err := c.Remove(context.Background()); err != nil {
+			logging.Warnf("remove container %s: %v", c.ID, err)
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Container) start(ctx context.Context, opts RunOptions) error {
+	if err := c.cli.StartContainerWithContext(c.ID, nil, ctx); err != nil {
+		return errors.Wrap(err, "StartContainer")
+	}
+
+	if opts.WaitHealthy {
+		if err := c.WaitHealthy(ctx); err != nil {
+			return errors.Wrap(err, "WaitHealthy")
+		}
+	}
+
+	if err := c.WaitFor(ctx, opts.WaitFor...); err != nil {
+		return errors.Wrap(err, "WaitFor")
+	}
+
+	return nil
+}
+
+// ContainerByName returns the handle of an existing container, e.g. to wait for it.
+//
+// Parameters:
+// - name: the name or ID of the container.
+//
+// Returns:
+// - *Container: the handle.
+// - error: an error if the container doesn't exist.
+func ContainerByName(name string) (*Container, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+
+	container, err := cli.InspectContainerWithContext(name, context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "InspectContainer")
+	}
+
+	return &Container{ID: container.ID, Name: strings.TrimPrefix(container.Name, "/"), cli: cli}, nil
+}
+
+// ContainerByLabel returns the handle of the newest container with label, see ContainerNameByLabel.
+func ContainerByLabel(label string) (*Container, error) {
+	name, err := ContainerNameByLabel(label)
+	if err != nil {
+		return nil, errors.Wrap(err, "ContainerNameByLabel")
+	}
+
+	if name == "" {
+		return nil, errors.Errorf("no container with label %s", label)
+	}
+
+	return ContainerByName(name)
+}
+
+// WaitHealthy blocks until the healthcheck of the container reports healthy or ctx is done.
+func (c *Container) WaitHealthy(ctx context.Context) error {
+	for {
+		container, err := c.cli.InspectContainerWithContext(c.ID, ctx)
+		if err != nil {
+			return errors.Wrap(err, "InspectContainer")
+		}
+
+		if !container.State.Running {
+			return errors.Errorf("container %s exited with code %d", c.Name, container.State.ExitCode)
+		}
+
+		if container.State.Health.Status == healthy {
+			return nil
+		}
+
+		if container.Config != nil && container.Config.Healthcheck == nil && container.State.Health.Status == "" {
+			return errors.Errorf("container %s has no healthcheck", c.Name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "container %s is %s", c.Name, container.State.Health.Status)
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// HostPort returns the `host:port` address a container port like `5432` is published on.
+func (c *Container) HostPort(ctx context.Context, port string) (string, error) {
+	container, err := c.cli.InspectContainerWithContext(c.ID, ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "InspectContainer")
+	}
+
+	if container.NetworkSettings != nil {
+		for _, binding := range container.NetworkSettings.Ports[containerPort(port)] {
+			host := binding.HostIP
+			if host == "" || host == "0.0.0.0" {
+				host = "127.0.0.1"
+			}
+			return host + ":" + binding.HostPort, nil
+		}
+	}
+
+	return "", errors.Errorf("port %s of container %s isn't published", port, c.Name)
+}
+
+// Wait blocks until the container exits and returns its exit code.
+func (c *Container) Wait(ctx context.Context) (int, error) {
+	code, err := c.cli.WaitContainerWithContext(c.ID, ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "WaitContainer")
+	}
+
+	return code, nil
+}
+
+// Logs returns stdout and stderr of the container, interleaved.
+func (c *Container) Logs(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	err := c.cli.Logs(docker.LogsOptions{
+		Context:      ctx,
+		Container:    c.ID,
+		OutputStream: &out,
+		ErrorStream:  &out,
+		Stdout:       true,
+		Stderr:       true,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Logs")
+	}
+
+	return out.String(), nil
+}
+
+// Exec runs cmd in the container and returns its output and exit code.
+// A non-zero exit code is no error, check ExecResult.ExitCode.
+func (c *Container) Exec(ctx context.Context, cmd ...string) (*ExecResult, error) {
+	exec, err := c.cli.CreateExec(docker.CreateExecOptions{
+		Container:    c.ID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+		Context:      ctx,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "CreateExec")
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = c.cli.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: &stdout,
+		ErrorStream:  &stderr,
+		Context:      ctx,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "StartExec")
+	}
+
+	inspect, err := c.cli.InspectExec(exec.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "InspectExec")
+	}
+
+	return &ExecResult{ExitCode: inspect.ExitCode, Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
+
+// CopyTo copies the local file or directory src into the container directory dstDir.
+func (c *Container) CopyTo(ctx context.Context, src, dstDir string) error {
+	var buf bytes.Buffer
+	if err := writeTar(&buf, src); err != nil {
+		return errors.Wrap(err, "writeTar")
+	}
+
+	err := c.cli.UploadToContainer(c.ID, docker.UploadToContainerOptions{
+		InputStream: &buf,
+		Path:        dstDir,
+		Context:     ctx,
+	})
+	if err != nil {
+		return errors.Wrap(err, "UploadToContainer")
+	}
+
+	return nil
+}
+
+// CopyFrom copies the file or directory src of the container into the local directory dstDir.
+func (c *Container) CopyFrom(ctx context.Context, src, dstDir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(c.cli.DownloadFromContainer(c.ID, docker.DownloadFromContainerOptions{
+			OutputStream: writer,
+			Path:         src,
+			Context:      ctx,
+		}))
+	}()
+	defer reader.Close()
+
+	if err := extractTar(reader, dstDir); err != nil {
+		return errors.Wrap(err, "extractTar")
+	}
+
+	return nil
+}
+
+// Stop stops the container, killing it after timeout.
+func (c *Container) Stop(ctx context.Context, timeout time.Duration) error {
+	logging.Infof("stop container %s", c.Name)
+	if err := c.cli.StopContainerWithContext(c.ID, uint(timeout.Seconds()), ctx); err != nil {
+		if _, ok := err.(*docker.ContainerNotRunning); ok {
+			return nil
+		}
+		return errors.Wrap(err, "StopContainer")
+	}
+
+	return nil
+}
+
+// Remove removes the container and its anonymous volumes, stopping it if running.
+func (c *Container) Remove(ctx context.Context) error {
+	logging.Infof("remove container %s", c.Name)
+	return removeContainer(ctx, c.cli, c.ID)
+}
+
+// ContainersByLabel returns the containers with label, running or not, newest first.
+//
+// Parameters:
+// - label: a label like `key=value` or `key`.
+//
+// Returns:
+// - []docker.APIContainers: the matching containers.
+// - error: an error if listing the containers fails.
+func ContainersByLabel(label string) ([]docker.APIContainers, error) {
+	cli, err := newClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "newClient")
+	}
+
+	containers, err := cli.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {label}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "ListContainers")
+	}
+
+	sort.SliceStable(containers, func(i, j int) bool {
+		return containers[i].Created > containers[j].Created
+	})
+
+	return containers, nil
+}
+
+// CleanupCmd as magelib.Cmd
+func CleanupCmd(label string) magelib.Cmd {
+	return func() error {
+		return Cleanup(label)
+	}
+}
+
+// Cleanup removes all containers with label, their anonymous volumes and the volumes with label.
+//
+// Parameters:
+// - label: a label like `key=value`, ManagedLabel removes all containers and volumes created by RunContainer.
+//
+// Returns:
+// - error: an error if a container or volume can't be removed.
+func Cleanup(label string) error {
+	containers, err := ContainersByLabel(label)
+	if err != nil {
+		return errors.Wrap(err, "ContainersByLabel")
+	}
+
+	cli, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "newClient")
+	}
+
+	for _, container := range containers {
+		logging.Infof("remove container %s", containerName(container))
+		if err := removeContainer(context.Background(), cli, container.ID); err != nil {
+			return errors.Wrapf(err, "remove container %s", containerName(container))
+		}
+	}
+
+	volumes, err := cli.ListVolumes(docker.ListVolumesOptions{
+		Filters: map[string][]string{"label": {label}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "ListVolumes")
+	}
+
+	for _, volume := range volumes {
+		logging.Infof("remove volume %s", volume.Name)
+		if err := cli.RemoveVolumeWithOptions(docker.RemoveVolumeOptions{Name: volume.Name}); err != nil && err != docker.ErrNoSuchVolume {
+			return errors.Wrapf(err, "remove volume %s", volume.Name)
+		}
+	}
+
+	return nil
+}
+
+func removeContainer(ctx context.Context, cli *docker.Client, id string) error {
+	err := cli.RemoveContainer(docker.RemoveContainerOptions{ID: id, RemoveVolumes: true, Force: true, Context: ctx})
+	if err != nil {
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			return nil
+		}
+		return errors.Wrap(err, "RemoveContainer")
+	}
+
+	return nil
+}
+
+func pullImage(ctx context.Context, cli *docker.Client, image string) error {
+	logging.Infof("pull image %s", image)
+
+	auth, err := registryAuth(image)
+	if err != nil {
+		return errors.Wrap(err, "registryAuth")
+	}
+
+	repo, tag := splitTag(image)
+	_, err = runJSONStream(os.Stdout, func(w io.Writer) error {
+		return cli.PullImage(docker.PullImageOptions{
+			Repository:    repo,
+			Tag:           tag,
+			OutputStream:  w,
+			RawJSONStream: true,
+			Context:       ctx,
+		}, auth)
+	})
+	if err != nil {
+		return errors.Wrap(err, "pull failed")
+	}
+
+	return nil
+}
+
+// containerPort appends the default protocol tcp to port if it has none.
+func containerPort(port string) docker.Port {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+
+	return docker.Port(port)
+}
+
+func containerName(container docker.APIContainers) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+
+	return container.ID
+}
+
+// writeTar writes src, a file or directory, to a tarball with paths relative to the parent of src.
+func writeTar(w io.Writer, src string) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(src)
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+
+		// symlinks are copied as links to their unchanged target
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// extractTar extracts the regular files and directories of the tarball r into dir.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return errors.Errorf("invalid path %s in archive", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
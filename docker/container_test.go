@@ -0,0 +1,221 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// muxFrame encodes s as frame of the multiplexed stdout (1) or stderr (2) stream of the Engine API.
+func muxFrame(stream byte, s string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(s)))
+	return append(header, s...)
+}
+
+// containerDaemon fakes the Engine API for a single container c1 whose health becomes healthy
+// after the first inspect. It records the requests by method and path.
+type containerDaemon struct {
+	mu       sync.Mutex
+	requests []string
+	created  docker.Config
+	host     docker.HostConfig
+	uploaded []string
+	inspects int
+}
+
+func (d *containerDaemon) serve(t *testing.T) {
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		d.mu.Lock()
+		d.requests = append(d.requests, r.Method+" "+path)
+		d.mu.Unlock()
+
+		switch r.Method + " " + path {
+		case "GET /images/postgres:16/json":
+			fmt.Fprint(w, `{"Id":"sha256:img"}`)
+		case "POST /containers/create":
+			body := struct {
+				docker.Config
+				HostConfig docker.HostConfig
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			d.created, d.host = body.Config, body.HostConfig
+			assert.Equal(t, "pg", r.URL.Query().Get("name"))
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"Id":"c1"}`)
+		case "POST /containers/c1/start", "POST /containers/c1/stop", "DELETE /containers/c1":
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /containers/c1/json":
+			d.mu.Lock()
+			d.inspects++
+			status := "starting"
+			if d.inspects > 1 {
+				status = "healthy"
+			}
+			d.mu.Unlock()
+			fmt.Fprintf(w, `{"Id":"c1","Name":"/pg","State":{"Running":true,"Health":{"Status":%q}},`+
+				`"Config":{"Healthcheck":{"Test":["CMD","true"]}},`+
+				`"NetworkSettings":{"Ports":{"5432/tcp":[{"HostIp":"0.0.0.0","HostPort":"49153"}]}}}`, status)
+		case "GET /containers/c1/logs":
+			w.Write(muxFrame(1, "ready\n"))
+			w.Write(muxFrame(2, "warning\n"))
+		case "POST /containers/c1/exec":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"Id":"e1"}`)
+		case "POST /exec/e1/start":
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			buf.Write(muxFrame(1, "out"))
+			buf.Write(muxFrame(2, "err"))
+			buf.Flush()
+			conn.Close()
+		case "GET /exec/e1/json":
+			fmt.Fprint(w, `{"ID":"e1","ExitCode":3}`)
+		case "PUT /containers/c1/archive":
+			assert.Equal(t, "/etc/app", r.URL.Query().Get("path"))
+			tr := tar.NewReader(r.Body)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				name := header.Name
+				if header.Typeflag == tar.TypeSymlink {
+					name += " -> " + header.Linkname
+				}
+				d.uploaded = append(d.uploaded, name)
+			}
+		case "GET /containers/c1/archive":
+			tw := tar.NewWriter(w)
+			tw.WriteHeader(&tar.Header{Name: "data/", Typeflag: tar.TypeDir, Mode: 0755})
+			tw.WriteHeader(&tar.Header{Name: "data/dump.sql", Typeflag: tar.TypeReg, Mode: 0644, Size: 6})
+			tw.Write([]byte("SELECT"))
+			tw.Close()
+		case "POST /containers/c1/wait":
+			fmt.Fprint(w, `{"StatusCode":0}`)
+		case "GET /containers/json":
+			assert.Equal(t, `{"label":["`+ManagedLabel+`"]}`, r.URL.Query().Get("filters"))
+			fmt.Fprint(w, `[{"Id":"c0","Names":["/old"],"Created":1},{"Id":"c1","Names":["/pg"],"Created":2}]`)
+		case "DELETE /containers/c0", "DELETE /volumes/pgdata":
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /volumes":
+			assert.Equal(t, `{"label":["`+ManagedLabel+`"]}`, r.URL.Query().Get("filters"))
+			fmt.Fprint(w, `{"Volumes":[{"Name":"pgdata"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func fastPolling(t *testing.T) {
+	interval := pollInterval
+	pollInterval = time.Millisecond
+	t.Cleanup(func() { pollInterval = interval })
+}
+
+func TestRunContainer(t *testing.T) {
+	fastPolling(t)
+	d := &containerDaemon{}
+	d.serve(t)
+
+	ctx := context.Background()
+	c, err := RunContainer(ctx, RunOptions{
+		Image: "postgres:16",
+		Name:  "pg",
+		Env:   map[string]string{"POSTGRES_PASSWORD": "test", "POSTGRES_DB": "app"},
+		Ports: []string{"5432"},
+		Mounts: []Mount{
+			{Source: "/tmp/init", Target: "/docker-entrypoint-initdb.d", ReadOnly: true},
+			{Type: "volume", Source: "pgdata", Target: "/var/lib/postgresql/data"},
+		},
+		Labels:      map[string]string{"suite": "integration"},
+		WaitHealthy: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "c1", c.ID)
+	assert.Equal(t, "pg", c.Name)
+	assert.Equal(t, 2, d.inspects)
+
+	assert.Equal(t, []string{"POSTGRES_DB=app", "POSTGRES_PASSWORD=test"}, d.created.Env)
+	assert.Equal(t, map[string]string{ManagedLabel: "true", "suite": "integration"}, d.created.Labels)
+	assert.Contains(t, d.created.ExposedPorts, docker.Port("5432/tcp"))
+	assert.Equal(t, []docker.PortBinding{{HostIP: "127.0.0.1"}}, d.host.PortBindings["5432/tcp"])
+	assert.Equal(t, []docker.HostMount{
+		{Type: "bind", Source: "/tmp/init", Target: "/docker-entrypoint-initdb.d", ReadOnly: true},
+		{Type: "volume", Source: "pgdata", Target: "/var/lib/postgresql/data", VolumeOptions: &docker.VolumeOptions{
+			Labels: map[string]string{ManagedLabel: "true", "suite": "integration"},
+		}},
+	}, d.host.Mounts)
+
+	addr, err := c.HostPort(ctx, "5432")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:49153", addr)
+
+	_, err = c.HostPort(ctx, "6379")
+	assert.Error(t, err)
+
+	logs, err := c.Logs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "ready\nwarning\n", logs)
+
+	result, err := c.Exec(ctx, "psql", "-c", "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, &ExecResult{ExitCode: 3, Stdout: "out", Stderr: "err"}, result)
+
+	src := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.MkdirAll(src, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "app.yaml"), []byte("debug: true\n"), 0644))
+	require.NoError(t, os.Symlink("app.yaml", filepath.Join(src, "current.yaml")))
+	require.NoError(t, c.CopyTo(ctx, src, "/etc/app"))
+	assert.Equal(t, []string{"config", "config/app.yaml", "config/current.yaml -> app.yaml"}, d.uploaded)
+
+	dst := t.TempDir()
+	require.NoError(t, c.CopyFrom(ctx, "/var/lib/data", dst))
+	dump, err := ioutil.ReadFile(filepath.Join(dst, "data", "dump.sql"))
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT", string(dump))
+
+	code, err := c.Wait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+
+	require.NoError(t, c.Stop(ctx, 5*time.Second))
+	require.NoError(t, c.Remove(ctx))
+
+	name, err := ContainerNameByLabel(ManagedLabel)
+	require.NoError(t, err)
+	assert.Equal(t, "pg", name)
+
+	d.requests = nil
+	require.NoError(t, Cleanup(ManagedLabel))
+	assert.Equal(t, []string{
+		"GET /containers/json", "DELETE /containers/c1", "DELETE /containers/c0", "GET /volumes", "DELETE /volumes/pgdata",
+	}, d.requests)
+}
+
+func TestExtractTarRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}))
+	require.NoError(t, tw.Close())
+
+	assert.Error(t, extractTar(&buf, t.TempDir()))
+}
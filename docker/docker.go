@@ -1,22 +1,33 @@
 package docker
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"os"
 	"strings"
 
 	"github.com/denkhaus/logging"
 	"github.com/denkhaus/magelib"
 	"github.com/denkhaus/magelib/shx"
 	docker "github.com/fsouza/go-dockerclient"
-	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/sh"
 	"github.com/pkg/errors"
 	pipe "gopkg.in/pipe.v2"
 )
 
 var (
-	Out            = sh.OutCmd("docker")
-	CraneDigestOut = sh.OutCmd("crane", "digest")
+	Out = sh.OutCmd("docker")
+
+	// CraneDigestOut returns the digest of the image reference in args like `crane digest`.
+	//
+	// Deprecated: use RemoteDigest, which doesn't need the crane binary.
+	CraneDigestOut magelib.OutCmdFunc = func(args ...string) (string, error) {
+		if len(args) != 1 {
+			return "", errors.Errorf("CraneDigestOut expects an image reference, got %q", args)
+		}
+
+		return RemoteDigest(context.Background(), args[0])
+	}
 )
 
 // RemoveUntaggedImages as magelib.Cmd
@@ -43,23 +54,22 @@ func RemoveUntaggedImages() error {
 	return shx.RunPipeVerbose(p)
 }
 
-// ContainerNameByLabel gets the name of a Docker container by its label.
+// ContainerNameByLabel gets the name of the newest Docker container with the label.
 //
-// Parameters: label (string) - the label of the Docker container.
-// Returns: name (string) - the name of the Docker container, err (error) - an error if the operation fails.
+// Parameters: label (string) - the label of the Docker container, e.g. `key=value`.
+// Returns: name (string) - the name of the Docker container or empty if none has the label, err (error) - an error if the operation fails.
 func ContainerNameByLabel(label string) (string, error) {
-	label = fmt.Sprintf("'label=%s'", label)
-	name, err := Out(
-		"ps",
-		"-n", "1",
-		"--filter", label,
-		"--format", "'{{.Names}}'",
-	)
+	containers, err := ContainersByLabel(label)
+	if err != nil || len(containers) == 0 {
+		return "", err
+	}
 
-	return name, err
+	return containerName(containers[0]), nil
 }
 
 // Build as magelib.Cmd
+//
+// Deprecated: use BuildImageCmd.
 func BuildCmd(moduleDir, tag string) magelib.Cmd {
 	return func() error {
 		return Build(moduleDir, tag)
@@ -70,23 +80,17 @@ func BuildCmd(moduleDir, tag string) magelib.Cmd {
 //
 // Parameters: moduleDir (string) - the directory of the module, tag (string) - the tag of the image.
 // Returns: error - an error if the operation fails.
+//
+// Deprecated: use BuildImage.
 func Build(moduleDir, tag string) error {
-	err := magelib.InDirectory(moduleDir, func() error {
-		logging.Infof("build image %s", tag)
-		output, err := Out("build", "-t", tag, ".")
-		fmt.Println(output)
-
-		if !strings.Contains(output, tag) {
-			return errors.New("docker build doesn't finish correctly")
-		}
-
-		return err
-	})
+	_, err := BuildImage(context.Background(), NewBuildOptions(moduleDir, WithTags(tag)))
 
 	return err
 }
 
 // BuildWithFile as magelib.Cmd
+//
+// Deprecated: use BuildImageCmd with WithDockerfile.
 func BuildWithFileCmd(moduleDir, dockerfilePath, tag string) magelib.Cmd {
 	return func() error {
 		return BuildWithFile(moduleDir, dockerfilePath, tag)
@@ -97,21 +101,23 @@ func BuildWithFileCmd(moduleDir, dockerfilePath, tag string) magelib.Cmd {
 //
 // Parameters:
 // - moduleDir: the directory of the module.
-// - dockerfilePath: the path to the Dockerfile.
+// - dockerfilePath: the path to the Dockerfile, relative to moduleDir.
 // - tag: the tag to give to the Docker image.
 //
 // Returns:
 // - error: an error if the operation fails.
+//
+// Deprecated: use BuildImage with WithDockerfile.
 func BuildWithFile(moduleDir, dockerfilePath, tag string) error {
-	err := magelib.InDirectory(moduleDir, func() error {
-		logging.Infof("build image %s", tag)
-		return sh.RunV("docker", "build", "-t", tag, "-f", dockerfilePath, ".")
-	})
+	_, err := BuildImage(context.Background(), NewBuildOptions(moduleDir,
+		WithDockerfile(dockerfilePath), WithTags(tag)))
 
 	return err
 }
 
 // BuildWithArgs as magelib.Cmd
+//
+// Deprecated: use BuildImageCmd with WithBuildArgs.
 func BuildWithArgsCmd(moduleDir, tag string, args magelib.ArgsMap) magelib.Cmd {
 	return func() error {
 		return BuildWithArgs(moduleDir, tag, args)
@@ -122,30 +128,11 @@ func BuildWithArgsCmd(moduleDir, tag string, args magelib.ArgsMap) magelib.Cmd {
 //
 // Parameters: moduleDir (string) - the directory of the module, tag (string) - the tag of the image, args (magelib.ArgsMap) - the build arguments.
 // Returns: error - an error if the operation fails.
+//
+// Deprecated: use BuildImage with WithBuildArgs.
 func BuildWithArgs(moduleDir, tag string, args magelib.ArgsMap) error {
-	err := magelib.InDirectory(moduleDir, func() error {
-		logging.Infof("docker: build image %s with build args", tag)
-
-		buildArgs := createBuildArgs(args)
-		params := []string{"build", "--tag", tag}
-		params = append(params, buildArgs...)
-		params = append(params, ".")
-
-		p := []interface{}{"params: "}
-		for v := range params {
-			p = append(p, v)
-		}
-		logging.Info(p...)
-
-		output, err := Out(params...)
-		logging.Println(output)
-
-		if !strings.Contains(output, tag) {
-			return errors.New("docker build doesn't finish correctly")
-		}
-
-		return err
-	})
+	_, err := BuildImage(context.Background(), NewBuildOptions(moduleDir,
+		WithTags(tag), WithBuildArgs(args)))
 
 	return err
 }
@@ -159,9 +146,9 @@ func BuildWithArgs(moduleDir, tag string, args magelib.ArgsMap) error {
 // - string: the digest of the Docker image.
 // - error: an error if the operation fails.
 func ImageDigestLocal(tag string) (string, error) {
-	cli, err := docker.NewClientFromEnv()
+	cli, err := newClient()
 	if err != nil {
-		return "", errors.Wrap(err, "NewClientFromEnv")
+		return "", errors.Wrap(err, "newClient")
 	}
 
 	images, err := cli.ListImages(docker.ListImagesOptions{All: true})
@@ -197,11 +184,11 @@ func ImageDigestLocal(tag string) (string, error) {
 // - string: the digest of the Docker image.
 // - error: an error if the operation fails.
 func ImageDigestRemote(tag string) (string, error) {
-	mg.Deps(ensureCrane)
-	return CraneDigestOut(tag)
+	return RemoteDigest(context.Background(), tag)
 }
 
-// Push pushes a Docker image with the given tag.
+// Push pushes a Docker image with the given tag through the Engine API.
+// The credentials of the registry are resolved with Keychain.
 //
 // Parameters:
 // - tag: the tag of the Docker image to push.
@@ -210,7 +197,31 @@ func ImageDigestRemote(tag string) (string, error) {
 // - error: an error if the push operation fails.
 func Push(tag string) error {
 	logging.Infof("push image %s", tag)
-	return sh.RunV("docker", "push", tag)
+
+	auth, err := registryAuth(tag)
+	if err != nil {
+		return errors.Wrap(err, "registryAuth")
+	}
+
+	cli, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "newClient")
+	}
+
+	repo, tagName := splitTag(tag)
+	_, err = runJSONStream(os.Stdout, func(w io.Writer) error {
+		return cli.PushImage(docker.PushImageOptions{
+			Name:          repo,
+			Tag:           tagName,
+			OutputStream:  w,
+			RawJSONStream: true,
+		}, auth)
+	})
+	if err != nil {
+		return errors.Wrap(err, "push failed")
+	}
+
+	return nil
 }
 
 // PushCmd returns a magelib.Cmd that pushes a Docker image with the given tag.
@@ -300,25 +311,3 @@ func RemoveLocalImage(imageName string) error {
 
 	return nil
 }
-
-// createBuildArgs generates a list of Docker build arguments from a map of key-value pairs.
-//
-// Parameters:
-// - args (magelib.ArgsMap): a map of key-value pairs to be converted into build arguments.
-//
-// Returns:
-// - []string: a list of build arguments in the format "--build-arg key=value".
-func createBuildArgs(args magelib.ArgsMap) []string {
-	out := []string{}
-
-	if args == nil {
-		return out
-	}
-
-	for key, value := range args {
-		out = append(out, "--build-arg")
-		out = append(out, fmt.Sprintf("%s=%s", key, value))
-	}
-
-	return out
-}
@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/pkg/errors"
+)
+
+// craneOptions returns the options of registry requests, credentials are resolved with Keychain.
+func craneOptions(ctx context.Context) []crane.Option {
+	return []crane.Option{
+		crane.WithContext(ctx),
+		crane.WithAuthFromKeychain(Keychain),
+	}
+}
+
+// RemoteDigest retrieves the digest of the manifest of ref from its registry.
+//
+// Parameters:
+// - ctx: the context used to cancel the request.
+// - ref: the image reference, e.g. `registry.example.com/org/app:1.0.0`.
+//
+// Returns:
+// - string: the digest of the manifest, e.g. `sha256:...`.
+// - error: an error if the request fails.
+func RemoteDigest(ctx context.Context, ref string) (string, error) {
+	digest, err := crane.Digest(ref, craneOptions(ctx)...)
+	if err != nil {
+		return "", errors.Wrap(err, "Digest")
+	}
+
+	return digest, nil
+}
+
+// RemoteManifest retrieves the raw manifest of ref from its registry.
+//
+// Parameters:
+// - ctx: the context used to cancel the request.
+// - ref: the image reference.
+//
+// Returns:
+// - []byte: the manifest as sent by the registry.
+// - error: an error if the request fails.
+func RemoteManifest(ctx context.Context, ref string) ([]byte, error) {
+	manifest, err := crane.Manifest(ref, craneOptions(ctx)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Manifest")
+	}
+
+	return manifest, nil
+}
+
+// CopyImageCmd as magelib.Cmd
+func CopyImageCmd(src, dst string) magelib.Cmd {
+	return func() error {
+		return CopyImage(context.Background(), src, dst)
+	}
+}
+
+// CopyImage copies the image or manifest list src to dst, between registries if needed.
+//
+// Parameters:
+// - ctx: the context used to cancel the copy.
+// - src: the reference of the source image.
+// - dst: the reference of the destination image.
+//
+// Returns:
+// - error: an error if the copy fails.
+func CopyImage(ctx context.Context, src, dst string) error {
+	logging.Infof("copy image %s to %s", src, dst)
+	if err := crane.Copy(src, dst, craneOptions(ctx)...); err != nil {
+		return errors.Wrap(err, "Copy")
+	}
+
+	return nil
+}
+
+// TagRemoteCmd as magelib.Cmd
+func TagRemoteCmd(ref, tag string) magelib.Cmd {
+	return func() error {
+		return TagRemote(context.Background(), ref, tag)
+	}
+}
+
+// TagRemote adds tag to the remote image ref without pulling it.
+//
+// Parameters:
+// - ctx: the context used to cancel the request.
+// - ref: the image reference.
+// - tag: the new tag in the repository of ref, e.g. `latest`.
+//
+// Returns:
+// - error: an error if tagging fails.
+func TagRemote(ctx context.Context, ref, tag string) error {
+	logging.Infof("tag image %s as %s", ref, tag)
+	if err := crane.Tag(ref, tag, craneOptions(ctx)...); err != nil {
+		return errors.Wrap(err, "Tag")
+	}
+
+	return nil
+}
+
+// ListTags lists the tags of a repository.
+//
+// Parameters:
+// - ctx: the context used to cancel the request.
+// - repository: the repository, e.g. `registry.example.com/org/app`.
+//
+// Returns:
+// - []string: the tags as listed by the registry.
+// - error: an error if the request fails.
+func ListTags(ctx context.Context, repository string) ([]string, error) {
+	tags, err := crane.ListTags(repository, craneOptions(ctx)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "ListTags")
+	}
+
+	return tags, nil
+}
+
+// DeleteRemoteCmd as magelib.Cmd
+func DeleteRemoteCmd(ref string) magelib.Cmd {
+	return func() error {
+		return DeleteRemote(context.Background(), ref)
+	}
+}
+
+// DeleteRemote deletes the manifest of ref from its registry.
+//
+// Parameters:
+// - ctx: the context used to cancel the request.
+// - ref: the image reference. Registries may require a digest reference.
+//
+// Returns:
+// - error: an error if the deletion fails.
+func DeleteRemote(ctx context.Context, ref string) error {
+	logging.Infof("delete remote image %s", ref)
+	if err := crane.Delete(ref, craneOptions(ctx)...); err != nil {
+		return errors.Wrap(err, "Delete")
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry serves an in-process registry requiring the basic auth user:password.
+// The credentials are written to a docker config, DOCKER_CONFIG points to it.
+func fakeRegistry(t *testing.T) string {
+	handler := registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:password"))
+
+	dir := t.TempDir()
+	config := `{"auths":{"` + host + `":{"auth":"` + auth + `"}}}`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600))
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	return host
+}
+
+// pushRandomImage pushes a random image to ref and returns its digest.
+func pushRandomImage(t *testing.T, ref string) string {
+	image, err := random.Image(256, 1)
+	require.NoError(t, err)
+
+	tag, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(tag, image, remote.WithAuth(&authn.Basic{Username: "user", Password: "password"})))
+
+	digest, err := image.Digest()
+	require.NoError(t, err)
+	return digest.String()
+}
+
+func TestRegistry(t *testing.T) {
+	host := fakeRegistry(t)
+	ctx := context.Background()
+	digest := pushRandomImage(t, host+"/org/app:1.0.0")
+
+	remoteDigest, err := RemoteDigest(ctx, host+"/org/app:1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, digest, remoteDigest)
+
+	remoteDigest, err = ImageDigestRemote(host + "/org/app:1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, digest, remoteDigest)
+
+	remoteDigest, err = CraneDigestOut(host + "/org/app:1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, digest, remoteDigest)
+
+	manifest, err := RemoteManifest(ctx, host+"/org/app@"+digest)
+	require.NoError(t, err)
+	assert.Contains(t, string(manifest), `"layers"`)
+
+	require.NoError(t, TagRemote(ctx, host+"/org/app:1.0.0", "latest"))
+	require.NoError(t, CopyImage(ctx, host+"/org/app:1.0.0", host+"/mirror/app:1.0.0"))
+
+	tags, err := ListTags(ctx, host+"/org/app")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.0.0", "latest"}, tags)
+
+	remoteDigest, err = RemoteDigest(ctx, host+"/mirror/app:1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, digest, remoteDigest)
+
+	require.NoError(t, DeleteRemote(ctx, host+"/mirror/app:1.0.0"))
+	_, err = RemoteDigest(ctx, host+"/mirror/app:1.0.0")
+	assert.Error(t, err)
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	_, err = RemoteDigest(ctx, host+"/org/app:1.0.0")
+	assert.Error(t, err)
+}
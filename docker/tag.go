@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/git"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+// LatestTag is added to the tag set of releases without prerelease.
+const LatestTag = "latest"
+
+// TagCmd as magelib.Cmd
+func TagCmd(source string, targets ...string) magelib.Cmd {
+	return func() error {
+		return Tag(source, targets...)
+	}
+}
+
+// Tag tags the local image source with all targets.
+//
+// Parameters:
+// - source: the name or ID of the local image.
+// - targets: the new image references, e.g. `org/app:1.2.3`. A reference without tag is tagged `latest`.
+//
+// Returns:
+// - error: an error if source doesn't exist or a target is invalid.
+func Tag(source string, targets ...string) error {
+	cli, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "newClient")
+	}
+
+	for _, target := range targets {
+		logging.Infof("tag image %s as %s", source, target)
+
+		repo, tag := splitTag(target)
+		if err := cli.TagImage(source, docker.TagImageOptions{Repo: repo, Tag: tag}); err != nil {
+			return errors.Wrapf(err, "TagImage [%s]", target)
+		}
+	}
+
+	return nil
+}
+
+// PromoteCmd as magelib.Cmd
+func PromoteCmd(srcRef, dstRef string) magelib.Cmd {
+	return func() error {
+		return Promote(srcRef, dstRef)
+	}
+}
+
+// Promote publishes the image srcRef as dstRef without rebuilding it, e.g. from a dev to a prod registry.
+// The manifest is copied by digest between the registries, so dstRef refers to the identical image.
+// If srcRef only exists locally it is tagged and pushed instead.
+//
+// Parameters:
+// - srcRef: the reference of the image to promote.
+// - dstRef: the destination reference.
+//
+// Returns:
+// - error: an error if the copy or push fails or the promoted digest differs.
+func Promote(srcRef, dstRef string) error {
+	ctx := context.Background()
+
+	digest, err := RemoteDigest(ctx, srcRef)
+	if err != nil {
+		if !IsImageAvailable(srcRef) {
+			return errors.Wrap(err, "RemoteDigest")
+		}
+
+		logging.Warnf("image %s isn't available remotely, push local image", srcRef)
+		if err := Tag(srcRef, dstRef); err != nil {
+			return errors.Wrap(err, "Tag")
+		}
+
+		return Push(dstRef)
+	}
+
+	src, err := name.ParseReference(srcRef)
+	if err != nil {
+		return errors.Wrap(err, "ParseReference")
+	}
+
+	logging.Infof("promote image %s (%s) to %s", srcRef, digest, dstRef)
+	if err := CopyImage(ctx, src.Context().Digest(digest).String(), dstRef); err != nil {
+		return errors.Wrap(err, "CopyImage")
+	}
+
+	promoted, err := RemoteDigest(ctx, dstRef)
+	if err != nil {
+		return errors.Wrap(err, "RemoteDigest")
+	}
+
+	if promoted != digest {
+		return errors.Errorf("promoted image %s has digest %s, expected %s", dstRef, promoted, digest)
+	}
+
+	return nil
+}
+
+// SemverTags returns the references of image for the semver tag pointing at HEAD of the repository at path,
+// see VersionTags. If HEAD isn't tagged with a version, only the commit reference is returned,
+// so builds after a release never overwrite its tags.
+//
+// Parameters:
+// - image: the image repository, e.g. `ghcr.io/org/app`.
+// - path: the path of the git repository. The process working directory if empty.
+//
+// Returns:
+// - []string: the image references, e.g. `ghcr.io/org/app:1.2.3`.
+// - error: an error if the tags of HEAD can't be read.
+func SemverTags(image, path string) ([]string, error) {
+	repo := git.Repo(path)
+
+	commit, err := repo.ShortHash("HEAD")
+	if err != nil {
+		return nil, errors.Wrap(err, "ShortHash")
+	}
+
+	tagsAt, err := repo.TagsAt("HEAD")
+	if err != nil {
+		return nil, errors.Wrap(err, "TagsAt")
+	}
+
+	// the highest version wins if HEAD has several, e.g. v1.2.3 over v1.2.3-rc.2
+	var version *semver.Version
+	for _, tag := range tagsAt {
+		v, err := semver.NewVersion(tag)
+		if err == nil && (version == nil || v.GreaterThan(version)) {
+			version = v
+		}
+	}
+
+	tags := []string{commit}
+	if version != nil {
+		released, err := repo.Tags()
+		if err != nil {
+			return nil, errors.Wrap(err, "Tags")
+		}
+
+		if tags, err = VersionTags(version.Original(), commit, released...); err != nil {
+			return nil, errors.Wrap(err, "VersionTags")
+		}
+	}
+
+	refs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		refs = append(refs, image+":"+tag)
+	}
+
+	return refs, nil
+}
+
+// VersionTags returns the image tags of a release: commit, `1.2.3`, `1.2`, `1` and `latest` for v1.2.3.
+// Prereleases like v1.2.3-rc.1 only get commit and the full version, so they never move the floating tags.
+// A floating tag is left out if a higher release is in its range, so a maintenance release v1.1.5 after
+// v1.2.0 gets `1.1` but neither `1` nor `latest`.
+//
+// Parameters:
+// - version: the semver version with optional `v` prefix.
+// - commit: the commit hash, omitted if empty.
+// - released: the released versions, e.g. the tags of the repository. Other tags and prereleases are ignored.
+//
+// Returns:
+// - []string: the tags.
+// - error: an error if version isn't a semver version.
+func VersionTags(version, commit string, released ...string) ([]string, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid version [%s]", version)
+	}
+
+	tags := []string{}
+	if commit != "" {
+		tags = append(tags, commit)
+	}
+
+	// build metadata isn't allowed in image tags
+	full := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if v.Prerelease() != "" {
+		return append(tags, full+"-"+v.Prerelease()), nil
+	}
+
+	minor, major, latest := true, true, true
+	for _, release := range released {
+		r, err := semver.NewVersion(release)
+		if err != nil || r.Prerelease() != "" || !r.GreaterThan(v) {
+			continue
+		}
+
+		latest = false
+		if r.Major() == v.Major() {
+			major = false
+			minor = minor && r.Minor() != v.Minor()
+		}
+	}
+
+	tags = append(tags, full)
+	if minor {
+		tags = append(tags, fmt.Sprintf("%d.%d", v.Major(), v.Minor()))
+	}
+	if major {
+		tags = append(tags, fmt.Sprintf("%d", v.Major()))
+	}
+	if latest {
+		tags = append(tags, LatestTag)
+	}
+
+	return tags, nil
+}
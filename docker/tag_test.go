@@ -0,0 +1,123 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionTags(t *testing.T) {
+	released := []string{"v1.1.4", "v1.2.0", "v1.2.3", "v2.0.0-rc.1", "v2.0.0", "nightly"}
+	tests := []struct {
+		version  string
+		commit   string
+		released []string
+		tags     []string
+	}{
+		{"v1.2.3", "abc1234", nil, []string{"abc1234", "1.2.3", "1.2", "1", "latest"}},
+		{"0.4.0", "", nil, []string{"0.4.0", "0.4", "0", "latest"}},
+		{"v1.2.3+build.5", "abc1234", nil, []string{"abc1234", "1.2.3", "1.2", "1", "latest"}},
+		{"v2.0.0-rc.1", "abc1234", nil, []string{"abc1234", "2.0.0-rc.1"}},
+		{"v2.0.0", "abc1234", released, []string{"abc1234", "2.0.0", "2.0", "2", "latest"}},
+		{"v1.2.3", "abc1234", released, []string{"abc1234", "1.2.3", "1.2", "1"}},
+		{"v1.1.5", "abc1234", released, []string{"abc1234", "1.1.5", "1.1"}},
+		{"v1.1.3", "abc1234", released, []string{"abc1234", "1.1.3"}},
+	}
+
+	for _, test := range tests {
+		tags, err := VersionTags(test.version, test.commit, test.released...)
+		require.NoError(t, err, test.version)
+		assert.Equal(t, test.tags, tags, test.version)
+	}
+
+	_, err := VersionTags("release-1", "abc1234")
+	assert.Error(t, err)
+}
+
+func TestSemverTags(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(cmd.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	git("init", "-q", "-b", "main")
+	git("commit", "-q", "--allow-empty", "-m", "initial commit")
+	git("tag", "v1.4.2")
+	short := git("rev-parse", "--short", "HEAD")
+
+	refs, err := SemverTags("ghcr.io/org/app", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ghcr.io/org/app:" + short,
+		"ghcr.io/org/app:1.4.2",
+		"ghcr.io/org/app:1.4",
+		"ghcr.io/org/app:1",
+		"ghcr.io/org/app:latest",
+	}, refs)
+
+	// a build after the release only gets the commit tag
+	git("commit", "-q", "--allow-empty", "-m", "fix: after release")
+	git("tag", "nightly")
+	short = git("rev-parse", "--short", "HEAD")
+
+	refs, err = SemverTags("ghcr.io/org/app", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ghcr.io/org/app:" + short}, refs)
+
+	git("tag", "v1.5.0-rc.1")
+	git("tag", "v1.5.0")
+	refs, err = SemverTags("ghcr.io/org/app", dir)
+	require.NoError(t, err)
+	assert.Equal(t, "ghcr.io/org/app:1.5.0", refs[1])
+	assert.Len(t, refs, 5)
+
+	// a patch release on the older minor line keeps `1` and `latest` at v1.5.0
+	git("checkout", "-q", "-b", "release-1.4", "v1.4.2")
+	git("commit", "-q", "--allow-empty", "-m", "fix: backport")
+	git("tag", "v1.4.3")
+	short = git("rev-parse", "--short", "HEAD")
+
+	refs, err = SemverTags("ghcr.io/org/app", dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ghcr.io/org/app:" + short,
+		"ghcr.io/org/app:1.4.3",
+		"ghcr.io/org/app:1.4",
+	}, refs)
+}
+
+func TestTag(t *testing.T) {
+	tagged := []string{}
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		require.Equal(t, "/images/org/app:dev/tag", path)
+		tagged = append(tagged, r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	require.NoError(t, Tag("org/app:dev", "org/app:1.2.3", "registry.local:5000/org/app"))
+	assert.Equal(t, []string{"org/app:1.2.3", "registry.local:5000/org/app:latest"}, tagged)
+}
+
+func TestPromote(t *testing.T) {
+	host := fakeRegistry(t)
+	digest := pushRandomImage(t, host+"/dev/app:1.2.3")
+
+	require.NoError(t, Promote(host+"/dev/app:1.2.3", host+"/prod/app:1.2.3"))
+
+	promoted, err := RemoteDigest(context.Background(), host+"/prod/app:1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, digest, promoted)
+
+	assert.Error(t, Promote(host+"/dev/app:missing", host+"/prod/app:missing"))
+}
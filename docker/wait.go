@@ -0,0 +1,291 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/pkg/errors"
+)
+
+// DefaultWaitTimeout is the timeout of wait strategies if none is set.
+const DefaultWaitTimeout = time.Minute
+
+// WaitFor is a strategy that blocks until a container is ready.
+type WaitFor interface {
+	// WaitUntilReady returns nil once c is ready, or an error if the strategy times out or ctx is done.
+	WaitUntilReady(ctx context.Context, c *Container) error
+}
+
+// portProbe checks inside a container whether the port $1 accepts connections, with nc or bash.
+// It exits with 1 if the port is closed. Other codes mean it can't probe, e.g. 127 without nc and bash
+// or the usage error of an nc without `-z`.
+const portProbe = `if command -v nc >/dev/null 2>&1; then exec nc -z 127.0.0.1 "$1"; fi
+if command -v bash >/dev/null 2>&1; then exec bash -c 'exec 3<>"/dev/tcp/127.0.0.1/$1"' probe "$1"; fi
+exit 127`
+
+// closeTimeout is how long a connection to a host port is read from to tell whether it's closed right away.
+var closeTimeout = 100 * time.Millisecond
+
+// PortStrategy waits until a container port accepts connections.
+//
+// The userland proxy of docker accepts connections on the host port before the container port is listened on
+// and closes them if the container refuses. So the port is also probed from inside the container if it has
+// a shell with nc or bash, otherwise a connection to the host port must stay open.
+type PortStrategy struct {
+	port    string
+	timeout time.Duration
+	err     error
+}
+
+// ForPort waits until the published container port, e.g. `5432`, accepts connections.
+// Only TCP ports can be waited for, the strategy fails right away for others like `53/udp`.
+func ForPort(port string) *PortStrategy {
+	s := &PortStrategy{port: port, timeout: DefaultWaitTimeout}
+	if parts := strings.SplitN(port, "/", 2); len(parts) == 2 && parts[1] != "tcp" {
+		s.err = errors.Errorf("port %s isn't a TCP port", port)
+	}
+
+	return s
+}
+
+// WithTimeout sets the timeout of the strategy.
+func (s *PortStrategy) WithTimeout(timeout time.Duration) *PortStrategy {
+	s.timeout = timeout
+	return s
+}
+
+// WaitUntilReady implements WaitFor.
+func (s *PortStrategy) WaitUntilReady(ctx context.Context, c *Container) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var addr string
+	probe := true
+	port := strings.SplitN(s.port, "/", 2)[0]
+	return poll(ctx, c, "port "+s.port+" of "+c.Name, func() (bool, error) {
+		// the port is resolved once poll made sure the container is running
+		if addr == "" {
+			var err error
+			if addr, err = c.HostPort(ctx, s.port); err != nil {
+				return false, errors.Wrap(err, "HostPort")
+			}
+		}
+
+		if !hostPortOpen(ctx, addr) {
+			return false, nil
+		}
+
+		if !probe {
+			return true, nil
+		}
+
+		result, err := c.Exec(ctx, "sh", "-c", portProbe, "probe", port)
+		if err != nil {
+			return false, errors.Wrap(err, "Exec")
+		}
+
+		switch result.ExitCode {
+		case 0:
+			return true, nil
+		case 1:
+			return false, nil
+		default:
+			logging.Warnf("container %s can't probe port %s (exit code %d), relying on the host port",
+				c.Name, s.port, result.ExitCode)
+			probe = false
+			return true, nil
+		}
+	})
+}
+
+// hostPortOpen reports whether a connection to addr succeeds and isn't closed right away.
+func hostPortOpen(ctx context.Context, addr string) bool {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(closeTimeout)); err != nil {
+		return false
+	}
+
+	// servers may send a greeting or wait for the client, both keep the connection open
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		return true
+	}
+
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// LogStrategy waits until a text appears in the logs of a container.
+type LogStrategy struct {
+	text    string
+	times   int
+	timeout time.Duration
+}
+
+// ForLog waits until text appears in the stdout or stderr logs.
+func ForLog(text string) *LogStrategy {
+	return &LogStrategy{text: text, times: 1, timeout: DefaultWaitTimeout}
+}
+
+// Times waits until text appeared n times, e.g. for servers restarting during initialization.
+func (s *LogStrategy) Times(n int) *LogStrategy {
+	s.times = n
+	return s
+}
+
+// WithTimeout sets the timeout of the strategy.
+func (s *LogStrategy) WithTimeout(timeout time.Duration) *LogStrategy {
+	s.timeout = timeout
+	return s
+}
+
+// WaitUntilReady implements WaitFor.
+func (s *LogStrategy) WaitUntilReady(ctx context.Context, c *Container) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return poll(ctx, c, "log "+s.text+" of "+c.Name, func() (bool, error) {
+		logs, err := c.Logs(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, "Logs")
+		}
+
+		return strings.Count(logs, s.text) >= s.times, nil
+	})
+}
+
+// HTTPStrategy waits until an HTTP endpoint of a container answers with the expected status.
+type HTTPStrategy struct {
+	port    string
+	path    string
+	status  int
+	timeout time.Duration
+}
+
+// ForHTTP waits until a GET request of path on the published port answers 200 OK.
+func ForHTTP(port, path string) *HTTPStrategy {
+	return &HTTPStrategy{port: port, path: path, status: http.StatusOK, timeout: DefaultWaitTimeout}
+}
+
+// WithStatus sets the expected status code.
+func (s *HTTPStrategy) WithStatus(status int) *HTTPStrategy {
+	s.status = status
+	return s
+}
+
+// WithTimeout sets the timeout of the strategy.
+func (s *HTTPStrategy) WithTimeout(timeout time.Duration) *HTTPStrategy {
+	s.timeout = timeout
+	return s
+}
+
+// WaitUntilReady implements WaitFor.
+func (s *HTTPStrategy) WaitUntilReady(ctx context.Context, c *Container) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var url string
+	return poll(ctx, c, s.path+" on port "+s.port+" of "+c.Name, func() (bool, error) {
+		if url == "" {
+			addr, err := c.HostPort(ctx, s.port)
+			if err != nil {
+				return false, errors.Wrap(err, "HostPort")
+			}
+			url = "http://" + addr + "/" + strings.TrimPrefix(s.path, "/")
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, errors.Wrap(err, "NewRequest")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, nil
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode == s.status, nil
+	})
+}
+
+// HealthyStrategy waits until the docker healthcheck of a container reports healthy.
+type HealthyStrategy struct {
+	timeout time.Duration
+}
+
+// ForHealthy waits until the healthcheck of the image or of RunOptions.HealthCheck passes.
+func ForHealthy() *HealthyStrategy {
+	return &HealthyStrategy{timeout: DefaultWaitTimeout}
+}
+
+// WithTimeout sets the timeout of the strategy.
+func (s *HealthyStrategy) WithTimeout(timeout time.Duration) *HealthyStrategy {
+	s.timeout = timeout
+	return s
+}
+
+// WaitUntilReady implements WaitFor.
+func (s *HealthyStrategy) WaitUntilReady(ctx context.Context, c *Container) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return c.WaitHealthy(ctx)
+}
+
+// WaitFor blocks until all strategies report the container ready, one after another.
+func (c *Container) WaitFor(ctx context.Context, strategies ...WaitFor) error {
+	for _, strategy := range strategies {
+		if err := strategy.WaitUntilReady(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// poll calls ready every pollInterval until it reports true, returns an error, c exits or ctx is done.
+func poll(ctx context.Context, c *Container, what string, ready func() (bool, error)) error {
+	for {
+		container, err := c.cli.InspectContainerWithContext(c.ID, ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.Wrapf(ctx.Err(), "wait for %s", what)
+			}
+			return errors.Wrap(err, "InspectContainer")
+		}
+
+		if !container.State.Running {
+			return errors.Errorf("container %s exited with code %d", c.Name, container.State.ExitCode)
+		}
+
+		ok, err := ready()
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "wait for %s", what)
+		case <-time.After(pollInterval):
+		}
+	}
+}
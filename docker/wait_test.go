@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitFor(t *testing.T) {
+	fastPolling(t)
+
+	var mu sync.Mutex
+	healthChecks := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		assert.Equal(t, "/health", r.URL.Path)
+		if healthChecks++; healthChecks < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	_, httpPort, err := net.SplitHostPort(srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	// a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, closedPort, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	l.Close()
+
+	// a port accepting and closing connections like the userland proxy without a listening container port
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer proxy.Close()
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, proxyPort, err := net.SplitHostPort(proxy.Addr().String())
+	require.NoError(t, err)
+
+	logCalls := 0
+	probes := []string{}
+	exitCodes := []int{}
+	fakeDaemon(t, func(w http.ResponseWriter, r *http.Request, path string) {
+		switch path {
+		case "/containers/json":
+			fmt.Fprint(w, `[{"Id":"c1","Names":["/app"],"Created":1}]`)
+		case "/containers/app/json", "/containers/c1/json":
+			fmt.Fprintf(w, `{"Id":"c1","Name":"/app","State":{"Running":true,"Health":{"Status":"healthy"}},`+
+				`"NetworkSettings":{"Ports":{"8080/tcp":[{"HostIp":"127.0.0.1","HostPort":%q}],"5432/tcp":[{"HostPort":%q}],`+
+				`"9000/tcp":[{"HostPort":%q}]}}}`,
+				httpPort, closedPort, proxyPort)
+		case "/containers/c2/json":
+			fmt.Fprint(w, `{"Id":"c2","Name":"/gone","State":{"Running":false,"ExitCode":1}}`)
+		case "/containers/c1/exec":
+			body := struct{ Cmd []string }{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			require.Len(t, body.Cmd, 5)
+			assert.Equal(t, []string{"sh", "-c", portProbe, "probe"}, body.Cmd[:4])
+			mu.Lock()
+			probes = append(probes, body.Cmd[4])
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"Id":"e1"}`)
+		case "/exec/e1/start":
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+		case "/exec/e1/json":
+			mu.Lock()
+			code := 0
+			if len(exitCodes) > 0 {
+				code, exitCodes = exitCodes[0], exitCodes[1:]
+			}
+			mu.Unlock()
+			fmt.Fprintf(w, `{"ID":"e1","ExitCode":%d}`, code)
+		case "/containers/c1/logs":
+			mu.Lock()
+			logCalls++
+			logs := "starting\n" + strings.Repeat("ready\n", logCalls)
+			mu.Unlock()
+			w.Write(muxFrame(1, logs))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	c, err := ContainerByLabel("app=web")
+	require.NoError(t, err)
+	assert.Equal(t, "c1", c.ID)
+	assert.Equal(t, "app", c.Name)
+
+	// the port isn't listened on inside the container at the first probe
+	exitCodes = []int{1, 0}
+	ctx := context.Background()
+	require.NoError(t, c.WaitFor(ctx,
+		ForPort("8080"),
+		ForHTTP("8080", "health"),
+		ForLog("ready").Times(3),
+		ForHealthy().WithTimeout(time.Second),
+	))
+	assert.Equal(t, 3, healthChecks)
+	assert.Equal(t, 3, logCalls)
+	assert.Equal(t, []string{"8080", "8080"}, probes)
+
+	// containers without nc or bash, or with an nc without -z, rely on the host port
+	for _, code := range []int{127, 2} {
+		probes, exitCodes = nil, []int{code}
+		require.NoError(t, ForPort("8080/tcp").WaitUntilReady(ctx, c))
+		assert.Equal(t, []string{"8080"}, probes)
+	}
+
+	err = ForPort("9000").WithTimeout(50*time.Millisecond).WaitUntilReady(ctx, c)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = ForPort("53/udp").WaitUntilReady(ctx, c)
+	assert.EqualError(t, err, "port 53/udp isn't a TCP port")
+
+	err = ForPort("5432").WithTimeout(20*time.Millisecond).WaitUntilReady(ctx, c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wait for port 5432 of app")
+
+	err = ForHTTP("8080", "/health").WithStatus(http.StatusNoContent).WithTimeout(20*time.Millisecond).WaitUntilReady(ctx, c)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = ForLog("panic").WithTimeout(20*time.Millisecond).WaitUntilReady(ctx, c)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// an exited container fails without waiting for the timeout
+	gone := &Container{ID: "c2", Name: "gone", cli: c.cli}
+	for _, strategy := range []WaitFor{ForPort("8080"), ForHTTP("8080", "/health"), ForLog("ready")} {
+		err = strategy.WaitUntilReady(ctx, gone)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "container gone exited with code 1")
+	}
+}
@@ -0,0 +1,201 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const (
+	// DefaultUsernameEnv is the environment variable HTTPEnvAuth reads the username from by default.
+	DefaultUsernameEnv = "GIT_USERNAME"
+	// DefaultTokenEnv is the environment variable HTTPEnvAuth reads the password or token from by default.
+	DefaultTokenEnv = "GIT_TOKEN"
+)
+
+// AuthProvider resolves the authentication method used to talk to the remote at repoURL.
+type AuthProvider func(repoURL string) (transport.AuthMethod, error)
+
+// SSHAgentAuth authenticates over SSH with the keys held by the agent at SSH_AUTH_SOCK.
+//
+// Parameters:
+// - user: the SSH user. If empty, the user of the repository URL is used.
+//
+// Returns:
+// - AuthProvider: the provider resolving the agent based auth method.
+func SSHAgentAuth(user string) AuthProvider {
+	return func(repoURL string) (transport.AuthMethod, error) {
+		auth, err := ssh.NewSSHAgentAuth(sshUser(user, repoURL))
+		if err != nil {
+			return nil, errors.Wrap(err, "NewSSHAgentAuth")
+		}
+
+		return auth, nil
+	}
+}
+
+// SSHKeyAuth authenticates over SSH with a private key file.
+//
+// Parameters:
+// - user: the SSH user. If empty, the user of the repository URL is used.
+// - keyPath: the path to the private key file. Environment variables are expanded.
+// - passphrase: the passphrase of an encrypted key, empty otherwise.
+//
+// Returns:
+// - AuthProvider: the provider resolving the key based auth method.
+func SSHKeyAuth(user, keyPath, passphrase string) AuthProvider {
+	return func(repoURL string) (transport.AuthMethod, error) {
+		auth, err := ssh.NewPublicKeysFromFile(sshUser(user, repoURL), os.ExpandEnv(keyPath), passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewPublicKeysFromFile")
+		}
+
+		return auth, nil
+	}
+}
+
+// WithHostKeyCallback sets the host key verification of the SSH auth method resolved by provider.
+// By default the known_hosts files are used.
+func WithHostKeyCallback(provider AuthProvider, callback gossh.HostKeyCallback) AuthProvider {
+	return func(repoURL string) (transport.AuthMethod, error) {
+		auth, err := provider(repoURL)
+		if err != nil {
+			return nil, err
+		}
+
+		switch a := auth.(type) {
+		case *ssh.PublicKeys:
+			a.HostKeyCallback = callback
+		case *ssh.PublicKeysCallback:
+			a.HostKeyCallback = callback
+		default:
+			return nil, errors.Errorf("auth method %q is no ssh auth method", auth.Name())
+		}
+
+		return auth, nil
+	}
+}
+
+// HTTPEnvAuth authenticates over HTTP(S) with basic auth read from environment variables.
+// A token is sent as password, which is what GitHub, GitLab and Gitea expect for personal access tokens.
+//
+// Parameters:
+// - usernameEnv: the variable holding the username. Defaults to DefaultUsernameEnv if empty.
+// - tokenEnv: the variable holding the password or token. Defaults to DefaultTokenEnv if empty.
+//
+// Returns:
+// - AuthProvider: the provider resolving the basic auth method.
+func HTTPEnvAuth(usernameEnv, tokenEnv string) AuthProvider {
+	if usernameEnv == "" {
+		usernameEnv = DefaultUsernameEnv
+	}
+	if tokenEnv == "" {
+		tokenEnv = DefaultTokenEnv
+	}
+
+	return func(repoURL string) (transport.AuthMethod, error) {
+		token := os.Getenv(tokenEnv)
+		if token == "" {
+			return nil, errors.Errorf("environment variable %q is not set", tokenEnv)
+		}
+
+		username := os.Getenv(usernameEnv)
+		if username == "" {
+			// any non empty username is accepted for token auth
+			username = "git"
+		}
+
+		return &http.BasicAuth{
+			Username: username,
+			Password: token,
+		}, nil
+	}
+}
+
+// CredentialHelperAuth authenticates over HTTP(S) with the credentials returned by `git credential fill`,
+// which asks the credential helpers configured in the git config.
+//
+// Parameters:
+// - cwd: the directory `git credential` is run in, so that repository local config applies.
+//
+// Returns:
+// - AuthProvider: the provider resolving the basic auth method.
+func CredentialHelperAuth(cwd string) AuthProvider {
+	return func(repoURL string) (transport.AuthMethod, error) {
+		ep, err := transport.NewEndpoint(repoURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewEndpoint")
+		}
+
+		if ep.Protocol != "http" && ep.Protocol != "https" {
+			return nil, errors.Errorf("credential helpers are not supported for protocol %q", ep.Protocol)
+		}
+
+		host := ep.Host
+		if ep.Port > 0 {
+			host = fmt.Sprintf("%s:%d", ep.Host, ep.Port)
+		}
+
+		input := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n",
+			ep.Protocol, host, strings.TrimPrefix(ep.Path, "/"))
+		if ep.User != "" {
+			input += fmt.Sprintf("username=%s\n", ep.User)
+		}
+
+		var stderr = new(bytes.Buffer)
+		cmd := exec.Command("git", "credential", "fill")
+		cmd.Stdin = strings.NewReader(input + "\n")
+		cmd.Stderr = stderr
+		cmd.Dir = cwd
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "git credential fill err: [%s]", stderr.String())
+		}
+
+		auth := &http.BasicAuth{}
+		s := bufio.NewScanner(bytes.NewReader(out))
+		for s.Scan() {
+			kv := strings.SplitN(s.Text(), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			switch kv[0] {
+			case "username":
+				auth.Username = kv[1]
+			case "password":
+				auth.Password = kv[1]
+			}
+		}
+
+		if auth.Password == "" {
+			return nil, errors.Errorf("no credentials found for %q", repoURL)
+		}
+
+		return auth, nil
+	}
+}
+
+// sshUser returns user or, if empty, the user of the repository URL.
+func sshUser(user, repoURL string) string {
+	if user != "" {
+		return user
+	}
+
+	if ep, err := transport.NewEndpoint(repoURL); err == nil && ep.User != "" {
+		return ep.User
+	}
+
+	return ssh.DefaultUsername
+}
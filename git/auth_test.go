@@ -0,0 +1,304 @@
+package git
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	gohttp "net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+)
+
+// sshStandIn is a minimal ssh server which runs git-upload-pack and
+// git-receive-pack locally for clients presenting the authorized key.
+type sshStandIn struct {
+	listener   net.Listener
+	authorized ssh.PublicKey
+}
+
+func newSSHStandIn(authorized ssh.PublicKey) (*sshStandIn, error) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sshStandIn{listener: listener, authorized: authorized}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(s.authorized.Marshal()) {
+				return nil, fmt.Errorf("unknown public key for %q", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	go s.serve(config)
+	return s, nil
+}
+
+func (s *sshStandIn) URL(path string) string {
+	return fmt.Sprintf("ssh://git@%s%s", s.listener.Addr(), path)
+}
+
+func (s *sshStandIn) Close() error {
+	return s.listener.Close()
+}
+
+func (s *sshStandIn) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+
+			for newChannel := range chans {
+				if newChannel.ChannelType() != "session" {
+					newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+					continue
+				}
+
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go s.handleSession(channel, requests)
+			}
+		}()
+	}
+}
+
+func (s *sshStandIn) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			req.Reply(false, nil)
+			continue
+		}
+
+		req.Reply(true, nil)
+		command := string(req.Payload[4:])
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+
+		var status uint32
+		if err := cmd.Run(); err != nil {
+			status = 1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = uint32(exitErr.Sys().(syscall.WaitStatus).ExitStatus())
+			}
+		}
+
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		channel.SendRequest("exit-status", false, payload)
+		return
+	}
+}
+
+// generateKey creates a passphrase protected key pair with ssh-keygen.
+func (suite *repositoryTest) generateKey(passphrase string) (string, ssh.PublicKey) {
+	keyPath := filepath.Join(suite.tempDir, "id_rsa")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "rsa", "-b", "2048",
+		"-m", "PEM", "-N", passphrase, "-f", keyPath).CombinedOutput()
+	suite.Require().NoError(err, string(out))
+
+	pub, err := ioutil.ReadFile(keyPath + ".pub")
+	suite.Require().NoError(err)
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(pub)
+	suite.Require().NoError(err)
+
+	return keyPath, key
+}
+
+func (suite *repositoryTest) TestSSHKeyAuth() {
+	keyPath, pub := suite.generateKey("secret")
+
+	server, err := newSSHStandIn(pub)
+	suite.Require().NoError(err)
+	defer server.Close()
+
+	originURL := server.URL(filepath.Join(suite.tempDir, "origin.git"))
+	auth := WithHostKeyCallback(SSHKeyAuth("", keyPath, "secret"), ssh.InsecureIgnoreHostKey())
+
+	rep, err := NewGitRepository(filepath.Join(suite.tempDir, "work"), originURL, WithAuth(auth))
+	suite.Require().NoError(err)
+	suite.Require().NoError(rep.Clone(nil))
+
+	suite.commitFile(rep, "CHANGES.md", "add changes")
+	suite.Require().NoError(rep.Push(nil))
+	suite.Require().NoError(rep.Fetch(nil))
+
+	other, err := NewGitRepository(filepath.Join(suite.tempDir, "other"), suite.originURL)
+	suite.Require().NoError(err)
+	suite.Require().NoError(other.Clone(nil))
+	suite.FileExists(filepath.Join(other.Path(), "CHANGES.md"))
+
+	wrongPass := WithHostKeyCallback(SSHKeyAuth("", keyPath, "wrong"), ssh.InsecureIgnoreHostKey())
+	rep, err = NewGitRepository(filepath.Join(suite.tempDir, "denied"), originURL, WithAuth(wrongPass))
+	suite.Require().NoError(err)
+	suite.Error(rep.Clone(nil))
+}
+
+func (suite *repositoryTest) TestSSHAgentAuth() {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	suite.Require().NoError(err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	suite.Require().NoError(err)
+
+	keyring := agent.NewKeyring()
+	suite.Require().NoError(keyring.Add(agent.AddedKey{PrivateKey: key}))
+
+	socket := filepath.Join(suite.tempDir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	suite.Require().NoError(err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	suite.T().Setenv("SSH_AUTH_SOCK", socket)
+
+	server, err := newSSHStandIn(signer.PublicKey())
+	suite.Require().NoError(err)
+	defer server.Close()
+
+	originURL := server.URL(filepath.Join(suite.tempDir, "origin.git"))
+	auth := WithHostKeyCallback(SSHAgentAuth(""), ssh.InsecureIgnoreHostKey())
+
+	rep, err := NewGitRepository(filepath.Join(suite.tempDir, "work"), originURL, WithAuth(auth))
+	suite.Require().NoError(err)
+	suite.Require().NoError(rep.Clone(nil))
+	suite.FileExists(filepath.Join(rep.Path(), "README.md"))
+}
+
+func (suite *repositoryTest) TestHTTPEnvAuth() {
+	suite.T().Setenv(DefaultUsernameEnv, "")
+	suite.T().Setenv(DefaultTokenEnv, "")
+
+	provider := HTTPEnvAuth("", "")
+	_, err := provider(suite.originURL)
+	suite.Error(err)
+
+	suite.T().Setenv(DefaultTokenEnv, "token")
+	auth, err := provider(suite.originURL)
+	suite.Require().NoError(err)
+	suite.Equal(&http.BasicAuth{Username: "git", Password: "token"}, auth)
+
+	suite.T().Setenv(DefaultUsernameEnv, "alice")
+	server := suite.smartHTTPServer("alice", "token")
+	defer server.Close()
+
+	originURL := server.URL + "/origin.git"
+	rep, err := NewGitRepository(filepath.Join(suite.tempDir, "work"), originURL, WithAuth(provider))
+	suite.Require().NoError(err)
+	suite.Require().NoError(rep.Clone(nil))
+	suite.FileExists(filepath.Join(rep.Path(), "README.md"))
+
+	suite.commitFile(rep, "CHANGES.md", "add changes")
+	suite.Require().NoError(rep.Push(nil))
+	suite.Require().NoError(rep.Fetch(nil))
+
+	other := suite.cloneRepository("other")
+	suite.FileExists(filepath.Join(other.Path(), "CHANGES.md"))
+
+	rep, err = NewGitRepository(filepath.Join(suite.tempDir, "anonymous"), originURL)
+	suite.Require().NoError(err)
+	suite.Error(rep.Clone(nil))
+
+	suite.T().Setenv(DefaultTokenEnv, "wrong")
+	rep, err = NewGitRepository(filepath.Join(suite.tempDir, "denied"), originURL, WithAuth(provider))
+	suite.Require().NoError(err)
+	suite.Error(rep.Clone(nil))
+}
+
+// smartHTTPServer serves the repositories in the temp dir with `git http-backend`
+// to clients sending the basic auth credentials, others get 401 Unauthorized.
+func (suite *repositoryTest) smartHTTPServer(username, password string) *httptest.Server {
+	gitPath, err := exec.LookPath("git")
+	suite.Require().NoError(err)
+
+	backend := &cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		// REMOTE_USER enables pushes through receive-pack
+		Env: []string{"GIT_PROJECT_ROOT=" + suite.tempDir, "GIT_HTTP_EXPORT_ALL=1", "REMOTE_USER=" + username},
+	}
+
+	return httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != username || pass != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(gohttp.StatusUnauthorized)
+			return
+		}
+
+		backend.ServeHTTP(w, r)
+	}))
+}
+
+func (suite *repositoryTest) TestCredentialHelperAuth() {
+	rep := suite.cloneRepository("work")
+
+	helper := "!f() { test \"$1\" = get && echo username=alice && echo password=secret; }; f"
+	out, err := exec.Command("git", "-C", rep.Path(), "config", "credential.helper", helper).CombinedOutput()
+	suite.Require().NoError(err, string(out))
+
+	provider := CredentialHelperAuth(rep.Path())
+	auth, err := provider("https://git.example.com/org/repo.git")
+	suite.Require().NoError(err)
+	suite.Equal(&http.BasicAuth{Username: "alice", Password: "secret"}, auth)
+
+	_, err = provider(suite.originURL)
+	suite.Error(err)
+}
@@ -0,0 +1,398 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// UnownedPath groups the files without matching CODEOWNERS rule in an authorship report.
+const UnownedPath = "(unowned)"
+
+// CodeOwnersRule maps a CODEOWNERS path pattern to its owners.
+type CodeOwnersRule struct {
+	Pattern string
+	Owners  []string
+}
+
+// AuthorStats are the statistics of an author within a path.
+type AuthorStats struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Lines is the number of lines last modified by the author according to blame.
+	Lines int `json:"lines"`
+	// Commits is the number of commits of the author touching the path.
+	Commits     int       `json:"commits"`
+	LastTouched time.Time `json:"lastTouched"`
+}
+
+// PathStats are the author statistics of a file or of the files matched by a CODEOWNERS rule.
+type PathStats struct {
+	Path        string         `json:"path"`
+	Owners      []string       `json:"owners,omitempty"`
+	Lines       int            `json:"lines"`
+	LastTouched time.Time      `json:"lastTouched"`
+	Authors     []*AuthorStats `json:"authors"`
+}
+
+// AuthorshipReport holds the author statistics of all paths at a revision.
+type AuthorshipReport struct {
+	Revision string       `json:"revision"`
+	Paths    []*PathStats `json:"paths"`
+}
+
+type authorshipConfig struct {
+	globs      []string
+	codeOwners []CodeOwnersRule
+}
+
+// AuthorshipOption configures Authorship.
+type AuthorshipOption func(*authorshipConfig)
+
+// WithAuthorshipPaths limits the report to files matching one of globs.
+func WithAuthorshipPaths(globs ...string) AuthorshipOption {
+	return func(c *authorshipConfig) {
+		c.globs = append(c.globs, globs...)
+	}
+}
+
+// WithCodeOwners groups the files by the last matching rule instead of reporting every file.
+func WithCodeOwners(rules []CodeOwnersRule) AuthorshipOption {
+	return func(c *authorshipConfig) {
+		c.codeOwners = rules
+	}
+}
+
+// ParseCodeOwners reads rules in the CODEOWNERS format, `<pattern> <owner>...` per line.
+// Empty lines and comments are skipped.
+func ParseCodeOwners(r io.Reader) ([]CodeOwnersRule, error) {
+	rules := []CodeOwnersRule{}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rules = append(rules, CodeOwnersRule{Pattern: fields[0], Owners: fields[1:]})
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(err, "Scan")
+	}
+
+	return rules, nil
+}
+
+// Match reports whether file is matched by the rule pattern.
+// A leading slash anchors the pattern to the repository root, patterns matching a directory match all files below it.
+func (r CodeOwnersRule) Match(file string) bool {
+	pattern := r.Pattern
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+
+	if !anchored && !strings.Contains(pattern, "/") {
+		// unanchored names match at any depth, like in .gitignore
+		pattern = "**/" + pattern
+	}
+
+	return matchGlob(pattern, file) || matchGlob(pattern+"/**", file)
+}
+
+// Authorship computes per path author statistics at revision.
+// Lines are attributed with `git blame`, commits and last touched dates are collected from the history of revision.
+//
+// Parameters:
+// - revision: the revision to report. Defaults to HEAD if empty.
+// - opts: options to filter paths and group them by CODEOWNERS rules.
+//
+// Returns:
+// - *AuthorshipReport: the statistics, paths sorted by name and authors by lines.
+// - error: an error if reading the history fails.
+func (p *GitRepository) Authorship(revision string, opts ...AuthorshipOption) (*AuthorshipReport, error) {
+	cfg := authorshipConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	r, err := p.repository()
+	if err != nil {
+		return nil, errors.Wrap(err, "repository")
+	}
+
+	hash, err := resolveRevision(r, revision)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolveRevision")
+	}
+
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "CommitObject")
+	}
+
+	report := AuthorshipReport{Revision: hash.String()}
+	stats := map[string]*PathStats{}
+	authors := map[string]map[string]*AuthorStats{}
+
+	author := func(group, email string) *AuthorStats {
+		if authors[group] == nil {
+			authors[group] = map[string]*AuthorStats{}
+		}
+
+		a, ok := authors[group][email]
+		if !ok {
+			a = &AuthorStats{Email: email}
+			authors[group][email] = a
+			stats[group].Authors = append(stats[group].Authors, a)
+		}
+
+		return a
+	}
+
+	files, err := commit.Files()
+	if err != nil {
+		return nil, errors.Wrap(err, "Files")
+	}
+
+	err = files.ForEach(func(f *object.File) error {
+		group, owners, ok := cfg.group(f.Name)
+		if !ok {
+			return nil
+		}
+
+		if binary, err := f.IsBinary(); err != nil || binary {
+			return err
+		}
+
+		lines, err := p.blameAuthors(hash.String(), f.Name)
+		if err != nil {
+			return errors.Wrapf(err, "blameAuthors [%s]", f.Name)
+		}
+
+		if _, ok := stats[group]; !ok {
+			stats[group] = &PathStats{Path: group, Owners: owners}
+		}
+
+		for _, email := range lines {
+			author(group, email).Lines++
+			stats[group].Lines++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "ForEach")
+	}
+
+	iter, err := r.Log(&git.LogOptions{From: hash})
+	if err != nil {
+		return nil, errors.Wrap(err, "Log")
+	}
+
+	err = iter.ForEach(func(c *object.Commit) error {
+		// merges don't change lines on their own
+		if c.NumParents() > 1 {
+			return nil
+		}
+
+		changed, err := changedPaths(c)
+		if err != nil {
+			return errors.Wrapf(err, "changedPaths [%s]", c.Hash)
+		}
+
+		touched := map[string]bool{}
+		for _, name := range changed {
+			group, _, ok := cfg.group(name)
+			if !ok || stats[group] == nil || touched[group] {
+				continue
+			}
+			touched[group] = true
+
+			a := author(group, c.Author.Email)
+			a.Commits++
+			if a.Name == "" {
+				// the log is walked newest first
+				a.Name = c.Author.Name
+			}
+
+			if c.Author.When.After(a.LastTouched) {
+				a.LastTouched = c.Author.When
+			}
+
+			if c.Author.When.After(stats[group].LastTouched) {
+				stats[group].LastTouched = c.Author.When
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "ForEach")
+	}
+
+	for _, s := range stats {
+		sort.SliceStable(s.Authors, func(i, j int) bool {
+			if s.Authors[i].Lines != s.Authors[j].Lines {
+				return s.Authors[i].Lines > s.Authors[j].Lines
+			}
+			return s.Authors[i].Email < s.Authors[j].Email
+		})
+
+		report.Paths = append(report.Paths, s)
+	}
+
+	sort.Slice(report.Paths, func(i, j int) bool {
+		return report.Paths[i].Path < report.Paths[j].Path
+	})
+
+	return &report, nil
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *AuthorshipReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(r); err != nil {
+		return errors.Wrap(err, "Encode")
+	}
+
+	return nil
+}
+
+// WriteMarkdown writes the report as Markdown table with a row per path and author.
+func (r *AuthorshipReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("| Path | Owners | Author | Lines | Commits | Last touched |\n")
+	b.WriteString("|------|--------|--------|------:|--------:|--------------|\n")
+
+	for _, p := range r.Paths {
+		for _, a := range p.Authors {
+			author := a.Email
+			if a.Name != "" {
+				author = fmt.Sprintf("%s <%s>", a.Name, a.Email)
+			}
+
+			lastTouched := ""
+			if !a.LastTouched.IsZero() {
+				lastTouched = a.LastTouched.Format("2006-01-02")
+			}
+
+			fmt.Fprintf(&b, "| %s | %s | %s | %d | %d | %s |\n",
+				markdownCell(p.Path), markdownCell(strings.Join(p.Owners, " ")),
+				markdownCell(author), a.Lines, a.Commits, lastTouched)
+		}
+	}
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return errors.Wrap(err, "WriteString")
+	}
+
+	return nil
+}
+
+// group returns the report path of file and its owners, false if file is excluded from the report.
+func (c *authorshipConfig) group(file string) (string, []string, bool) {
+	if len(c.globs) > 0 {
+		matched := false
+		for _, glob := range c.globs {
+			if matchGlob(glob, file) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return "", nil, false
+		}
+	}
+
+	if c.codeOwners == nil {
+		return file, nil, true
+	}
+
+	// the last matching rule takes precedence
+	for i := len(c.codeOwners) - 1; i >= 0; i-- {
+		if rule := c.codeOwners[i]; rule.Match(file) {
+			return rule.Pattern, rule.Owners, true
+		}
+	}
+
+	return UnownedPath, nil, true
+}
+
+// blameAuthors returns the author email of every line of file at revision.
+// git.Blame of go-git v4 fails on most files with history, so the git binary does the line attribution.
+func (p *GitRepository) blameAuthors(revision, file string) ([]string, error) {
+	out, err := gitOutputIn(context.Background(), p.path, "blame", "--line-porcelain", revision, "--", file)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := []string{}
+	for _, line := range splitLines(out) {
+		if strings.HasPrefix(line, "author-mail ") {
+			email := strings.TrimPrefix(line, "author-mail ")
+			emails = append(emails, strings.Trim(email, "<>"))
+		}
+	}
+
+	return emails, nil
+}
+
+// changedPaths returns the paths changed by c compared to its first parent.
+func changedPaths(c *object.Commit) ([]string, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, "Tree")
+	}
+
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, errors.Wrap(err, "Parent")
+		}
+
+		if parentTree, err = parent.Tree(); err != nil {
+			return nil, errors.Wrap(err, "Tree")
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "DiffTree")
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		paths = append(paths, name)
+	}
+
+	return paths, nil
+}
+
+func markdownCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
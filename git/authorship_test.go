@@ -0,0 +1,114 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorship(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	commit := func(author, message string) {
+		runGit(t, dir, "add", ".")
+		runGit(t, dir, "commit", "-q", "--author", author, "--date", "2024-01-02T10:00:00Z", "-m", message)
+	}
+
+	runGit(t, dir, "init", "-q", "-b", "main")
+	write("api/main.go", "package main\n\nfunc main() {\n}\n")
+	write("docs/README.md", "# Docs\n")
+	commit("Alice <alice@example.com>", "initial commit")
+
+	write("api/main.go", "package main\n\nfunc main() {\n\tprintln()\n}\n")
+	write("api/util.go", "package main\n")
+	commit("Bob <bob@example.com>", "add util")
+
+	repo, err := NewGitRepository(dir, "")
+	require.NoError(t, err)
+
+	report, err := repo.Authorship("")
+	require.NoError(t, err)
+	require.Len(t, report.Paths, 3)
+
+	main := report.Paths[0]
+	assert.Equal(t, "api/main.go", main.Path)
+	assert.Equal(t, 5, main.Lines)
+	require.Len(t, main.Authors, 2)
+	assert.Equal(t, AuthorStats{Name: "Alice", Email: "alice@example.com", Lines: 4, Commits: 1, LastTouched: main.Authors[0].LastTouched}, *main.Authors[0])
+	assert.Equal(t, "bob@example.com", main.Authors[1].Email)
+	assert.Equal(t, 1, main.Authors[1].Lines)
+	assert.Equal(t, 1, main.Authors[1].Commits)
+	assert.Equal(t, "2024-01-02", main.LastTouched.UTC().Format("2006-01-02"))
+
+	rules, err := ParseCodeOwners(strings.NewReader("# owners\n*.go @backend\n/api/util.go @tools # generated\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []CodeOwnersRule{
+		{Pattern: "*.go", Owners: []string{"@backend"}},
+		{Pattern: "/api/util.go", Owners: []string{"@tools"}},
+	}, rules)
+
+	report, err = repo.Authorship("HEAD", WithCodeOwners(rules))
+	require.NoError(t, err)
+
+	paths := []string{}
+	for _, p := range report.Paths {
+		paths = append(paths, p.Path)
+	}
+	assert.Equal(t, []string{"(unowned)", "*.go", "/api/util.go"}, paths)
+	assert.Equal(t, []string{"@backend"}, report.Paths[1].Owners)
+	assert.Equal(t, 5, report.Paths[1].Lines)
+
+	report, err = repo.Authorship("HEAD~1", WithAuthorshipPaths("docs/**"), WithCodeOwners(rules))
+	require.NoError(t, err)
+	require.Len(t, report.Paths, 1)
+	assert.Equal(t, UnownedPath, report.Paths[0].Path)
+
+	var out bytes.Buffer
+	require.NoError(t, report.WriteJSON(&out))
+
+	decoded := AuthorshipReport{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, report.Revision, decoded.Revision)
+	assert.Equal(t, "alice@example.com", decoded.Paths[0].Authors[0].Email)
+
+	out.Reset()
+	require.NoError(t, report.WriteMarkdown(&out))
+	assert.Equal(t, "| Path | Owners | Author | Lines | Commits | Last touched |\n"+
+		"|------|--------|--------|------:|--------:|--------------|\n"+
+		"| (unowned) |  | Alice <alice@example.com> | 1 | 1 | 2024-01-02 |\n", out.String())
+}
+
+func TestCodeOwnersRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		match   bool
+	}{
+		{"*", "a/b/c.go", true},
+		{"*.go", "a/b/c.go", true},
+		{"*.go", "a/b/c.md", false},
+		{"/docs/", "docs/a/b.md", true},
+		{"/docs/", "src/docs/b.md", false},
+		{"docs", "src/docs/b.md", true},
+		{"apps/", "apps/web/main.go", true},
+		{"/build/*.sh", "build/a.sh", true},
+		{"/build/*.sh", "build/sub/a.sh", false},
+		{"**/logs", "a/logs/x.log", true},
+	}
+
+	for _, test := range tests {
+		rule := CodeOwnersRule{Pattern: test.pattern}
+		assert.Equal(t, test.match, rule.Match(test.file), "%s %s", test.pattern, test.file)
+	}
+}
@@ -0,0 +1,389 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultChangelogTemplate renders a Markdown section with one sub section per commit type.
+const DefaultChangelogTemplate = `## {{.Version}} ({{.Date}})
+{{- if .Breaking}}
+
+### BREAKING CHANGES
+{{range .Breaking}}
+- {{if .Scope}}**{{.Scope}}:** {{end}}{{.Subject}} ({{.ShortHash}})
+{{- end}}
+{{- end}}
+{{- range .Sections}}
+
+### {{.Title}}
+{{range .Scopes}}{{$scope := .Scope}}{{range .Entries}}
+- {{if $scope}}**{{$scope}}:** {{end}}{{.Subject}} ({{.ShortHash}})
+{{- end}}{{end}}
+{{- end}}
+`
+
+var issueReference = regexp.MustCompile(`#(\d+)\b`)
+
+// changelogTypes defines the order and titles of the changelog sections.
+var changelogTypes = []struct {
+	Type  string
+	Title string
+}{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance Improvements"},
+	{"refactor", "Code Refactoring"},
+	{"revert", "Reverts"},
+	{"docs", "Documentation"},
+	{"build", "Build System"},
+	{"ci", "Continuous Integration"},
+	{"test", "Tests"},
+	{"style", "Styles"},
+	{"chore", "Chores"},
+}
+
+const otherChangesTitle = "Other Changes"
+
+// Issue is an issue reference like `#42` found in a commit message.
+type Issue struct {
+	ID  string
+	URL string
+}
+
+// ChangelogEntry is a single commit in the changelog.
+type ChangelogEntry struct {
+	Hash      string
+	ShortHash string
+	Type      string
+	Scope     string
+	// Subject is the commit subject with issue references rendered as Markdown links if an issue URL is configured.
+	Subject  string
+	Body     string
+	Breaking bool
+	Issues   []Issue
+}
+
+// ChangelogScope groups the entries of a section by scope. Entries without scope have an empty Scope.
+type ChangelogScope struct {
+	Scope   string
+	Entries []ChangelogEntry
+}
+
+// ChangelogSection groups the entries of one commit type.
+type ChangelogSection struct {
+	Type   string
+	Title  string
+	Scopes []ChangelogScope
+}
+
+// ChangelogData is passed to the changelog template.
+type ChangelogData struct {
+	Version  string
+	Date     string
+	From     string
+	To       string
+	Breaking []ChangelogEntry
+	Sections []ChangelogSection
+}
+
+type changelogConfig struct {
+	template string
+	issueURL string
+	version  string
+	full     bool
+}
+
+// ChangelogOption configures Changelog.
+type ChangelogOption func(*changelogConfig)
+
+// WithChangelogTemplate renders the changelog with a custom text/template receiving ChangelogData.
+func WithChangelogTemplate(tmpl string) ChangelogOption {
+	return func(c *changelogConfig) {
+		c.template = tmpl
+	}
+}
+
+// WithIssueURL links issue references. The format receives the issue number,
+// e.g. `https://github.com/denkhaus/magelib/issues/%s`.
+func WithIssueURL(format string) ChangelogOption {
+	return func(c *changelogConfig) {
+		c.issueURL = format
+	}
+}
+
+// WithChangelogVersion sets the version heading of the section. By default the tag at `to` is used,
+// or `Unreleased` if there is none.
+func WithChangelogVersion(version string) ChangelogOption {
+	return func(c *changelogConfig) {
+		c.version = version
+	}
+}
+
+// WithChangelogFullHistory renders all commits reachable from `to` if from is empty,
+// instead of starting at the most recent tag before to.
+func WithChangelogFullHistory() ChangelogOption {
+	return func(c *changelogConfig) {
+		c.full = true
+	}
+}
+
+// Changelog renders the commits between from and to as Markdown.
+//
+// Parameters:
+// - from: the exclusive start ref. Defaults to the most recent tag before to, or the whole history without tags.
+// - to: the inclusive end ref. Defaults to HEAD if empty.
+// - opts: options for template, issue links and version heading.
+//
+// Returns:
+// - string: the rendered changelog section.
+// - error: an error if reading the history or rendering fails.
+func Changelog(from, to string, opts ...ChangelogOption) (string, error) {
+	cfg := changelogConfig{template: DefaultChangelogTemplate}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	data, err := changelogData(from, to, &cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "changelogData")
+	}
+
+	tmpl, err := template.New("changelog").Parse(cfg.template)
+	if err != nil {
+		return "", errors.Wrap(err, "Parse")
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", errors.Wrap(err, "Execute")
+	}
+
+	return out.String(), nil
+}
+
+// PrependChangelog inserts content at the top of the changelog file at path, below a leading `# ` title if present.
+// The file is created if it doesn't exist.
+func PrependChangelog(path, content string) error {
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "ReadFile")
+	}
+
+	var title string
+	rest := string(existing)
+	if strings.HasPrefix(rest, "# ") {
+		parts := strings.SplitN(rest, "\n", 2)
+		title = parts[0] + "\n\n"
+		rest = ""
+		if len(parts) > 1 {
+			rest = strings.TrimLeft(parts[1], "\n")
+		}
+	}
+
+	out := title + strings.TrimRight(content, "\n") + "\n"
+	if rest != "" {
+		out += "\n" + rest
+	}
+
+	if err := ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	return nil
+}
+
+// previousTag returns the most recent tag before commitish, ignoring the tags pointing at commitish itself.
+// It returns an empty tag if there is none, so the changelog covers the whole history.
+func previousTag(ctx context.Context, commitish string) (string, error) {
+	// a root commit has no parent and no previous tag
+	if _, err := gitOutput(ctx, "rev-parse", "--verify", "--quiet", commitish+"^"); err != nil {
+		return "", nil
+	}
+
+	tags, err := gitOutput(ctx, "tag", "--merged", commitish+"^")
+	if err != nil {
+		return "", errors.Wrap(err, "git [tag --merged]")
+	}
+
+	if tags == "" {
+		return "", nil
+	}
+
+	tag, err := Repo("").MostRecentTag(commitish + "^")
+	if err != nil {
+		return "", errors.Wrap(err, "MostRecentTag")
+	}
+
+	return tag, nil
+}
+
+func changelogData(from, to string, cfg *changelogConfig) (*ChangelogData, error) {
+	ctx := context.Background()
+
+	if to == "" {
+		to = "HEAD"
+	}
+
+	if from == "" && !cfg.full {
+		var err error
+		if from, err = previousTag(ctx, to); err != nil {
+			return nil, errors.Wrap(err, "previousTag")
+		}
+	}
+
+	data := ChangelogData{
+		From:    from,
+		To:      to,
+		Version: cfg.version,
+	}
+
+	date, err := gitOutput(ctx, "log", "-1", "--format=%cd", "--date=short", to)
+	if err != nil {
+		return nil, errors.Wrap(err, "git [log -1]")
+	}
+	data.Date = date
+
+	if data.Version == "" {
+		data.Version, err = versionAt(to)
+		if err != nil {
+			return nil, errors.Wrap(err, "versionAt")
+		}
+	}
+
+	revRange := to
+	if from != "" {
+		revRange = from + ".." + to
+	}
+
+	// the same commits as in CalculateVersion, merge commits may bump the version too
+	messages, err := commitMessages(ctx, revRange)
+	if err != nil {
+		return nil, errors.Wrap(err, "commitMessages")
+	}
+
+	sections := map[string]*ChangelogSection{}
+	for _, m := range messages {
+		entry := newChangelogEntry(m[0], m[1], cfg.issueURL)
+		if entry.Breaking {
+			data.Breaking = append(data.Breaking, entry)
+		}
+
+		title := changelogTitle(entry.Type)
+		section, ok := sections[title]
+		if !ok {
+			section = &ChangelogSection{Type: entry.Type, Title: title}
+			sections[title] = section
+		}
+
+		section.add(entry)
+	}
+
+	for _, t := range changelogTypes {
+		if section, ok := sections[t.Title]; ok {
+			data.Sections = append(data.Sections, *section)
+		}
+	}
+
+	if section, ok := sections[otherChangesTitle]; ok {
+		data.Sections = append(data.Sections, *section)
+	}
+
+	return &data, nil
+}
+
+// versionAt returns the newest tag pointing at ref, or `Unreleased` if ref is not tagged.
+func versionAt(ref string) (string, error) {
+	tags, err := Repo("").TagsAt(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "TagsAt")
+	}
+
+	if len(tags) > 0 {
+		return tags[0], nil
+	}
+
+	return "Unreleased", nil
+}
+
+func newChangelogEntry(hash, message, issueURL string) ChangelogEntry {
+	entry := ChangelogEntry{
+		Hash:      hash,
+		ShortHash: hash,
+	}
+
+	if len(hash) > 7 {
+		entry.ShortHash = hash[:7]
+	}
+
+	if commit, ok := ParseConventionalCommit(hash, message); ok {
+		entry.Type = commit.Type
+		entry.Scope = commit.Scope
+		entry.Subject = commit.Subject
+		entry.Body = commit.Body
+		entry.Breaking = commit.Breaking
+	} else {
+		lines := strings.SplitN(strings.TrimSpace(message), "\n", 2)
+		entry.Subject = strings.TrimSpace(lines[0])
+		if len(lines) > 1 {
+			entry.Body = strings.TrimSpace(lines[1])
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, match := range issueReference.FindAllStringSubmatch(entry.Subject+"\n"+entry.Body, -1) {
+		if seen[match[1]] {
+			continue
+		}
+		seen[match[1]] = true
+
+		issue := Issue{ID: match[1]}
+		if issueURL != "" {
+			issue.URL = fmt.Sprintf(issueURL, match[1])
+		}
+		entry.Issues = append(entry.Issues, issue)
+	}
+
+	if issueURL != "" {
+		entry.Subject = issueReference.ReplaceAllStringFunc(entry.Subject, func(ref string) string {
+			return fmt.Sprintf("[%s](%s)", ref, fmt.Sprintf(issueURL, ref[1:]))
+		})
+	}
+
+	return entry
+}
+
+func changelogTitle(commitType string) string {
+	for _, t := range changelogTypes {
+		if t.Type == commitType {
+			return t.Title
+		}
+	}
+	return otherChangesTitle
+}
+
+// add appends entry to the scope group of the section. Entries without scope come first.
+func (s *ChangelogSection) add(entry ChangelogEntry) {
+	for i := range s.Scopes {
+		if s.Scopes[i].Scope == entry.Scope {
+			s.Scopes[i].Entries = append(s.Scopes[i].Entries, entry)
+			return
+		}
+	}
+
+	scope := ChangelogScope{Scope: entry.Scope, Entries: []ChangelogEntry{entry}}
+	if entry.Scope == "" {
+		s.Scopes = append([]ChangelogScope{scope}, s.Scopes...)
+		return
+	}
+
+	s.Scopes = append(s.Scopes, scope)
+}
@@ -0,0 +1,104 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/denkhaus/magelib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangelog(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "initial commit")
+	runGit(t, dir, "tag", "v1.0.0")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "fix(docker): handle buildkit output, closes #12")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat: add changelog")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat(git)!: drop package globals", "-m", "refs #7")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "update readme")
+	runGit(t, dir, "tag", "v2.0.0")
+
+	var changelog string
+	err := magelib.InDirectory(dir, func() (err error) {
+		changelog, err = Changelog("v1.0.0", "v2.0.0",
+			WithIssueURL("https://example.com/issues/%s"),
+		)
+		return err
+	})
+	assert.NoError(t, err)
+
+	hash := regexp.MustCompile(`\([0-9a-f]{7}\)`)
+	assert.Regexp(t, `^## v2\.0\.0 \(\d{4}-\d{2}-\d{2}\)\n`, changelog)
+	assert.Equal(t, `
+
+### BREAKING CHANGES
+
+- **git:** drop package globals (HASH)
+
+### Features
+
+- add changelog (HASH)
+- **git:** drop package globals (HASH)
+
+### Bug Fixes
+
+- **docker:** handle buildkit output, closes [#12](https://example.com/issues/12) (HASH)
+
+### Other Changes
+
+- update readme (HASH)
+`, hash.ReplaceAllString(changelog[len("## v2.0.0 (2006-01-02)"):], "(HASH)"))
+
+	err = magelib.InDirectory(dir, func() (err error) {
+		changelog, err = Changelog("", "",
+			WithChangelogTemplate(`{{.Version}} {{.From}}..{{.To}}{{range .Sections}}{{range .Scopes}}{{range .Entries}}{{range .Issues}} {{.ID}}{{end}}{{end}}{{end}}{{end}}`),
+		)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "v2.0.0 v1.0.0..HEAD 7 12", changelog)
+
+	err = magelib.InDirectory(dir, func() (err error) {
+		changelog, err = Changelog("v1.0.0", "HEAD~1",
+			WithChangelogTemplate(`{{.Version}}{{range .Sections}}{{range .Scopes}}{{range .Entries}}{{range .Issues}} {{.ID}}{{end}}{{end}}{{end}}{{end}}`),
+		)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Unreleased 7 12", changelog)
+
+	// a merge commit bumping the version is listed too
+	runGit(t, dir, "checkout", "-q", "-b", "plugins")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "wip")
+	runGit(t, dir, "checkout", "-q", "-")
+	runGit(t, dir, "merge", "-q", "--no-ff", "-m", "feat: plugin support", "plugins")
+
+	err = magelib.InDirectory(dir, func() (err error) {
+		changelog, err = Changelog("", "",
+			WithChangelogTemplate(`{{.From}}{{range .Sections}}{{range .Scopes}}{{range .Entries}} {{.Subject}}{{end}}{{end}}{{end}}`),
+		)
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "v2.0.0 plugin support wip", changelog)
+}
+
+func TestPrependChangelog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "CHANGELOG.md")
+
+	assert.NoError(t, PrependChangelog(path, "## v1.0.0\n\n- first\n"))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "## v1.0.0\n\n- first\n", string(content))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("# Changelog\n\n"+string(content)), 0644))
+	assert.NoError(t, PrependChangelog(path, "## v1.1.0\n\n- second\n\n"))
+
+	content, err = ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Changelog\n\n## v1.1.0\n\n- second\n\n## v1.0.0\n\n- first\n", string(content))
+}
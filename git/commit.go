@@ -0,0 +1,415 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var (
+	ErrNothingToCommit = errors.New("nothing to commit")
+)
+
+// Identity is the name and email used for authors, committers and taggers.
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// commitConfig holds the settings applied by CommitOption functions.
+type commitConfig struct {
+	author        *Identity
+	committer     *Identity
+	include       []string
+	exclude       []string
+	signOff       bool
+	allowEmpty    bool
+	gpgKeyFile    string
+	gpgPassphrase string
+	sshKeyFile    string
+}
+
+// CommitOption configures a commit created by CommitAll.
+type CommitOption func(*commitConfig)
+
+// WithAuthor sets the commit author. By default user.name and user.email of the git config are used.
+func WithAuthor(name, email string) CommitOption {
+	return func(c *commitConfig) {
+		c.author = &Identity{Name: name, Email: email}
+	}
+}
+
+// WithCommitter sets the committer. By default the author is used.
+func WithCommitter(name, email string) CommitOption {
+	return func(c *commitConfig) {
+		c.committer = &Identity{Name: name, Email: email}
+	}
+}
+
+// WithInclude stages only changed files matching one of the globs.
+// Globs are matched against slash separated paths relative to the repository root, `**` matches across directories.
+func WithInclude(globs ...string) CommitOption {
+	return func(c *commitConfig) {
+		c.include = append(c.include, globs...)
+	}
+}
+
+// WithExclude skips changed files matching one of the globs. Exclusion takes precedence over inclusion.
+func WithExclude(globs ...string) CommitOption {
+	return func(c *commitConfig) {
+		c.exclude = append(c.exclude, globs...)
+	}
+}
+
+// WithSignOff appends a Signed-off-by trailer of the committer to the commit message.
+func WithSignOff() CommitOption {
+	return func(c *commitConfig) {
+		c.signOff = true
+	}
+}
+
+// WithAllowEmpty allows a commit without staged changes.
+func WithAllowEmpty() CommitOption {
+	return func(c *commitConfig) {
+		c.allowEmpty = true
+	}
+}
+
+// WithGPGSigning signs the commit with the first key of an armored private keyring file.
+func WithGPGSigning(keyFile, passphrase string) CommitOption {
+	return func(c *commitConfig) {
+		c.gpgKeyFile = keyFile
+		c.gpgPassphrase = passphrase
+	}
+}
+
+// WithSSHSigning signs the commit with a private SSH key file using `ssh-keygen -Y sign`.
+func WithSSHSigning(keyFile string) CommitOption {
+	return func(c *commitConfig) {
+		c.sshKeyFile = keyFile
+	}
+}
+
+// CommitAll stages all changed files matching the include and exclude globs and commits them.
+// Files staged before the call have to match the globs too, otherwise nothing is committed.
+//
+// Parameters:
+// - message: the commit message.
+// - opts: the options configuring author, staging and signing.
+//
+// Returns:
+// - string: the hash of the new commit.
+// - error: ErrNothingToCommit if no changes are staged and empty commits are not allowed,
+// an error if staged files don't match the globs.
+func (p *GitRepository) CommitAll(message string, opts ...CommitOption) (string, error) {
+	cfg := commitConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	r, err := p.repository()
+	if err != nil {
+		return "", errors.Wrap(err, "repository")
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", errors.Wrap(err, "Worktree")
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return "", errors.Wrap(err, "Status")
+	}
+
+	// already staged files would be committed regardless of the globs
+	filtered := []string{}
+	for file, s := range status {
+		if s.Staging != git.Unmodified && s.Staging != git.Untracked && !cfg.matches(file) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	if len(filtered) > 0 {
+		sort.Strings(filtered)
+		return "", errors.Errorf("staged files %s don't match the include and exclude globs", strings.Join(filtered, ", "))
+	}
+
+	for file, s := range status {
+		if s.Worktree == git.Unmodified || !cfg.matches(file) {
+			continue
+		}
+
+		if _, err := w.Add(file); err != nil {
+			return "", errors.Wrapf(err, "Add [%s]", file)
+		}
+	}
+
+	if !cfg.allowEmpty {
+		staged, err := hasStagedChanges(w)
+		if err != nil {
+			return "", errors.Wrap(err, "hasStagedChanges")
+		}
+
+		if !staged {
+			return "", ErrNothingToCommit
+		}
+	}
+
+	author, err := p.identity(cfg.author)
+	if err != nil {
+		return "", errors.Wrap(err, "identity")
+	}
+
+	committer := author
+	if cfg.committer != nil {
+		committer = *cfg.committer
+	}
+
+	if cfg.signOff {
+		message = strings.TrimRight(message, "\n") +
+			"\n\nSigned-off-by: " + committer.Name + " <" + committer.Email + ">\n"
+	}
+
+	commitOpts := &git.CommitOptions{
+		Author:    author.signature(),
+		Committer: committer.signature(),
+	}
+
+	if cfg.gpgKeyFile != "" {
+		commitOpts.SignKey, err = readGPGKey(cfg.gpgKeyFile, cfg.gpgPassphrase)
+		if err != nil {
+			return "", errors.Wrap(err, "readGPGKey")
+		}
+	}
+
+	hash, err := w.Commit(message, commitOpts)
+	if err != nil {
+		return "", errors.Wrap(err, "Commit")
+	}
+
+	if cfg.sshKeyFile != "" {
+		hash, err = signCommitSSH(r, hash, cfg.sshKeyFile)
+		if err != nil {
+			return "", errors.Wrap(err, "signCommitSSH")
+		}
+	}
+
+	logging.Infof("committed [%s] in repository [%s]", hash, p.path)
+	return hash.String(), nil
+}
+
+// identity returns id if set, otherwise user.name and user.email from the git config of the repository.
+func (p *GitRepository) identity(id *Identity) (Identity, error) {
+	if id != nil {
+		return *id, nil
+	}
+
+	name, err := gitConfigValue(p.path, "user.name")
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "gitConfigValue [user.name]")
+	}
+
+	email, err := gitConfigValue(p.path, "user.email")
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "gitConfigValue [user.email]")
+	}
+
+	if name == "" {
+		name = Name
+	}
+	if email == "" {
+		email = Email
+	}
+
+	return Identity{Name: name, Email: email}, nil
+}
+
+func (id Identity) signature() *object.Signature {
+	return &object.Signature{
+		Name:  id.Name,
+		Email: id.Email,
+		When:  time.Now(),
+	}
+}
+
+// matches reports whether file should be staged.
+func (c *commitConfig) matches(file string) bool {
+	for _, glob := range c.exclude {
+		if matchGlob(glob, file) {
+			return false
+		}
+	}
+
+	if len(c.include) == 0 {
+		return true
+	}
+
+	for _, glob := range c.include {
+		if matchGlob(glob, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob where `**` matches any number of directories.
+// Globs without a slash are matched against the base name.
+func matchGlob(glob, file string) bool {
+	glob = strings.TrimPrefix(glob, "./")
+	if !strings.Contains(glob, "/") {
+		ok, _ := path.Match(glob, path.Base(file))
+		return ok
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				expr.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	ok, _ := regexp.MatchString(expr.String(), file)
+	return ok
+}
+
+func hasStagedChanges(w *git.Worktree) (bool, error) {
+	status, err := w.Status()
+	if err != nil {
+		return false, errors.Wrap(err, "Status")
+	}
+
+	for _, s := range status {
+		if s.Staging != git.Unmodified && s.Staging != git.Untracked {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func readGPGKey(keyFile, passphrase string) (*openpgp.Entity, error) {
+	f, err := os.Open(os.ExpandEnv(keyFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "Open")
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReadArmoredKeyRing")
+	}
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil {
+		return nil, errors.Errorf("no private key found in %q", keyFile)
+	}
+
+	entity := entities[0]
+	if entity.PrivateKey.Encrypted {
+		if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, errors.Wrap(err, "Decrypt")
+		}
+	}
+
+	return entity, nil
+}
+
+// signCommitSSH replaces the commit with a copy signed by the given SSH key and moves HEAD to it.
+func signCommitSSH(r *git.Repository, hash plumbing.Hash, keyFile string) (plumbing.Hash, error) {
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "CommitObject")
+	}
+
+	unsigned := r.Storer.NewEncodedObject()
+	if err := commit.EncodeWithoutSignature(unsigned); err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "EncodeWithoutSignature")
+	}
+
+	reader, err := unsigned.Reader()
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "Reader")
+	}
+	defer reader.Close()
+
+	var stderr = new(bytes.Buffer)
+	cmd := exec.Command("ssh-keygen", "-Y", "sign", "-n", "git", "-f", os.ExpandEnv(keyFile))
+	cmd.Stdin = reader
+	cmd.Stderr = stderr
+
+	signature, err := cmd.Output()
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "ssh-keygen -Y sign err: [%s]", stderr.String())
+	}
+
+	commit.PGPSignature = string(signature)
+
+	signed := r.Storer.NewEncodedObject()
+	if err := commit.Encode(signed); err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "Encode")
+	}
+
+	signedHash, err := r.Storer.SetEncodedObject(signed)
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "SetEncodedObject")
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "Head")
+	}
+
+	ref := plumbing.NewHashReference(head.Name(), signedHash)
+	if err := r.Storer.SetReference(ref); err != nil {
+		return plumbing.ZeroHash, errors.Wrap(err, "SetReference")
+	}
+
+	return signedHash, nil
+}
+
+// gitConfigValue returns the value of key from the git config seen in cwd, or an empty string if it is not set.
+func gitConfigValue(cwd, key string) (string, error) {
+	var stderr = new(bytes.Buffer)
+	cmd := exec.Command("git", "config", "--get", key)
+	cmd.Stderr = stderr
+	cmd.Dir = cwd
+
+	out, err := cmd.Output()
+	if err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok && exiterr.ExitCode() == 1 {
+			return "", nil
+		}
+
+		return "", errors.Wrapf(err, "git config --get %s err: [%s]", key, stderr.String())
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,155 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func (suite *repositoryTest) writeFiles(rep *GitRepository, files ...string) {
+	for _, file := range files {
+		path := filepath.Join(rep.Path(), file)
+		suite.Require().NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		suite.Require().NoError(ioutil.WriteFile(path, []byte(file+"\n"), 0644))
+	}
+}
+
+func (suite *repositoryTest) commitObject(rep *GitRepository, hash string) *object.Commit {
+	r, err := git.PlainOpen(rep.Path())
+	suite.Require().NoError(err)
+
+	head, err := r.Head()
+	suite.Require().NoError(err)
+	suite.Equal(hash, head.Hash().String())
+
+	commit, err := r.CommitObject(plumbing.NewHash(hash))
+	suite.Require().NoError(err)
+	return commit
+}
+
+func (suite *repositoryTest) TestCommitAllGlobs() {
+	rep := suite.cloneRepository("work")
+
+	out, err := exec.Command("git", "-C", rep.Path(), "config", "user.name", "Config User").CombinedOutput()
+	suite.Require().NoError(err, string(out))
+	out, err = exec.Command("git", "-C", rep.Path(), "config", "user.email", "config@example.com").CombinedOutput()
+	suite.Require().NoError(err, string(out))
+
+	suite.writeFiles(rep, "main.go", "notes.txt", "pkg/lib.go", "pkg/gen/skip.go")
+	suite.Require().NoError(os.Remove(filepath.Join(rep.Path(), "README.md")))
+
+	hash, err := rep.CommitAll("add go files",
+		WithInclude("**/*.go", "README.md"),
+		WithExclude("pkg/gen/**"),
+	)
+	suite.Require().NoError(err)
+
+	commit := suite.commitObject(rep, hash)
+	suite.Equal("Config User", commit.Author.Name)
+	suite.Equal("config@example.com", commit.Committer.Email)
+
+	stats, err := commit.Stats()
+	suite.Require().NoError(err)
+
+	files := []string{}
+	for _, stat := range stats {
+		files = append(files, stat.Name)
+	}
+	sort.Strings(files)
+	suite.Equal([]string{"README.md", "main.go", "pkg/lib.go"}, files)
+
+	_, err = rep.CommitAll("nothing", WithInclude("*.md"))
+	suite.Equal(ErrNothingToCommit, err)
+
+	hash, err = rep.CommitAll("empty", WithInclude("*.md"), WithAllowEmpty())
+	suite.Require().NoError(err)
+	suite.Equal("empty", suite.commitObject(rep, hash).Message)
+
+	// files staged before aren't committed if they don't pass the globs
+	out, err = exec.Command("git", "-C", rep.Path(), "add", "notes.txt", "pkg/gen/skip.go").CombinedOutput()
+	suite.Require().NoError(err, string(out))
+	suite.writeFiles(rep, "cmd/app.go")
+
+	_, err = rep.CommitAll("staged", WithInclude("**/*.go"), WithExclude("pkg/gen/**"))
+	suite.Require().Error(err)
+	suite.Contains(err.Error(), "staged files notes.txt, pkg/gen/skip.go don't match")
+	suite.commitObject(rep, hash)
+}
+
+func (suite *repositoryTest) TestCommitAllSignOff() {
+	rep := suite.cloneRepository("work")
+	suite.writeFiles(rep, "CHANGES.md")
+
+	hash, err := rep.CommitAll("add changes\n",
+		WithAuthor("Author", "author@example.com"),
+		WithCommitter("Committer", "committer@example.com"),
+		WithSignOff(),
+	)
+	suite.Require().NoError(err)
+
+	commit := suite.commitObject(rep, hash)
+	suite.Equal("Author", commit.Author.Name)
+	suite.Equal("Committer", commit.Committer.Name)
+	suite.Equal("add changes\n\nSigned-off-by: Committer <committer@example.com>\n", commit.Message)
+}
+
+func (suite *repositoryTest) TestCommitAllGPGSigning() {
+	entity, err := openpgp.NewEntity("Signer", "", "signer@example.com", nil)
+	suite.Require().NoError(err)
+
+	var private bytes.Buffer
+	w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	suite.Require().NoError(err)
+	suite.Require().NoError(entity.SerializePrivate(w, nil))
+	suite.Require().NoError(w.Close())
+
+	var public bytes.Buffer
+	w, err = armor.Encode(&public, openpgp.PublicKeyType, nil)
+	suite.Require().NoError(err)
+	suite.Require().NoError(entity.Serialize(w))
+	suite.Require().NoError(w.Close())
+
+	keyFile := filepath.Join(suite.tempDir, "signing.asc")
+	suite.Require().NoError(ioutil.WriteFile(keyFile, private.Bytes(), 0600))
+
+	rep := suite.cloneRepository("work")
+	suite.writeFiles(rep, "CHANGES.md")
+
+	hash, err := rep.CommitAll("signed", WithGPGSigning(keyFile, ""))
+	suite.Require().NoError(err)
+
+	_, err = suite.commitObject(rep, hash).Verify(public.String())
+	suite.NoError(err)
+}
+
+func (suite *repositoryTest) TestCommitAllSSHSigning() {
+	keyPath, _ := suite.generateKey("")
+
+	pub, err := ioutil.ReadFile(keyPath + ".pub")
+	suite.Require().NoError(err)
+
+	allowedSigners := filepath.Join(suite.tempDir, "allowed_signers")
+	suite.Require().NoError(ioutil.WriteFile(allowedSigners, append([]byte("signer@example.com "), pub...), 0644))
+
+	rep := suite.cloneRepository("work")
+	suite.writeFiles(rep, "CHANGES.md")
+
+	hash, err := rep.CommitAll("signed", WithSSHSigning(keyPath))
+	suite.Require().NoError(err)
+	suite.Contains(suite.commitObject(rep, hash).PGPSignature, "BEGIN SSH SIGNATURE")
+
+	out, err := exec.Command("git", "-C", rep.Path(),
+		"-c", "gpg.format=ssh",
+		"-c", "gpg.ssh.allowedSignersFile="+allowedSigners,
+		"verify-commit", hash).CombinedOutput()
+	suite.NoError(err, string(out))
+}
@@ -0,0 +1,140 @@
+package git
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OwnerMarkers are the file names marking a directory as owner of the files below it,
+// e.g. a Go module or a Docker build context. Used by ChangedDirs.
+var OwnerMarkers = []string{"go.mod", "Dockerfile"}
+
+// ChangedFiles returns the files changed between the merge base of base and head and head
+// in the repository in the process working directory.
+// Use Repo(path).ChangedFiles for other repositories.
+func ChangedFiles(base, head string, pathspecs ...string) ([]string, error) {
+	return Repo("").ChangedFiles(base, head, pathspecs...)
+}
+
+// ChangedDirs returns the owning directories of the files changed between the merge base of base and head and head
+// in the repository in the process working directory.
+// Use Repo(path).ChangedDirs for other repositories.
+func ChangedDirs(base, head string, pathspecs ...string) ([]string, error) {
+	return Repo("").ChangedDirs(base, head, pathspecs...)
+}
+
+// MergeBase returns the best common ancestor of a and b.
+func (r *LocalRepo) MergeBase(a, b string) (string, error) {
+	if a == "" || b == "" {
+		return "", ErrCommitNotDefined
+	}
+
+	commit, err := r.output("merge-base", a, b)
+	if err != nil {
+		return "", errors.Wrap(err, "git [merge-base]")
+	}
+
+	return commit, nil
+}
+
+// ChangedFiles returns the files changed on head since it diverged from base, like `git diff base...head`.
+// Renamed files are reported with their old and new path.
+//
+// Parameters:
+// - base: the ref head is compared to, e.g. `origin/main`.
+// - head: the ref with the changes. Defaults to HEAD if empty.
+// - pathspecs: limit the result to matching paths.
+//
+// Returns:
+// - []string: the changed paths relative to the repository root, sorted.
+// - error: an error if the refs can't be resolved.
+func (r *LocalRepo) ChangedFiles(base, head string, pathspecs ...string) ([]string, error) {
+	if head == "" {
+		head = "HEAD"
+	}
+
+	mergeBase, err := r.MergeBase(base, head)
+	if err != nil {
+		return nil, errors.Wrap(err, "MergeBase")
+	}
+
+	args := append([]string{"diff", "--name-only", "--no-renames", "-z", mergeBase, head, "--"}, pathspecs...)
+	out, err := r.rawOutput(args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "git [diff --name-only]")
+	}
+
+	files := splitNUL(out)
+	sort.Strings(files)
+	return files, nil
+}
+
+// ChangedDirs maps the files returned by ChangedFiles to the nearest parent directory
+// containing one of the OwnerMarkers at head. Files without owner are skipped.
+//
+// Parameters:
+// - base: the ref head is compared to, e.g. `origin/main`.
+// - head: the ref with the changes. Defaults to HEAD if empty.
+// - pathspecs: limit the result to matching paths.
+//
+// Returns:
+// - []string: the owning directories relative to the repository root, `.` for the root, sorted.
+// - error: an error if the refs can't be resolved.
+func (r *LocalRepo) ChangedDirs(base, head string, pathspecs ...string) ([]string, error) {
+	if head == "" {
+		head = "HEAD"
+	}
+
+	files, err := r.ChangedFiles(base, head, pathspecs...)
+	if err != nil {
+		return nil, errors.Wrap(err, "ChangedFiles")
+	}
+
+	out, err := r.rawOutput("ls-tree", "-r", "--full-tree", "--name-only", "-z", head)
+	if err != nil {
+		return nil, errors.Wrap(err, "git [ls-tree]")
+	}
+
+	owners := map[string]bool{}
+	for _, file := range splitNUL(out) {
+		for _, marker := range OwnerMarkers {
+			if path.Base(file) == marker {
+				owners[path.Dir(file)] = true
+			}
+		}
+	}
+
+	found := map[string]bool{}
+	dirs := []string{}
+	for _, file := range files {
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			if owners[dir] {
+				if !found[dir] {
+					found[dir] = true
+					dirs = append(dirs, dir)
+				}
+				break
+			}
+
+			if dir == "." {
+				break
+			}
+		}
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// splitNUL splits the NUL terminated paths of raw `-z` output. Only the final NUL is trimmed,
+// so whitespace at the start or end of a path is kept.
+func splitNUL(output string) []string {
+	if output == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(output, "\x00"), "\x00")
+}
@@ -0,0 +1,71 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	runGit(t, dir, "init", "-q", "-b", "main")
+	write("README.md", "readme")
+	write("api/go.mod", "module api")
+	write("api/main.go", "package main")
+	write("web/Dockerfile", "FROM scratch")
+	write("web/static/index.html", "index")
+	write("tools/go.mod", "module tools")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "initial commit")
+
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	write("api/main.go", "package main // changed")
+	write("web/static/my page.html", "page")
+	write("README.md", "changed")
+	write(" leading.txt", "leading")
+	write("web/trailing.txt ", "trailing")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "feature")
+
+	// changes on main after the feature branched off are not reported
+	runGit(t, dir, "checkout", "-q", "main")
+	write("tools/main.go", "package main")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "tools")
+
+	repo := Repo(dir)
+
+	files, err := repo.ChangedFiles("main", "feature")
+	require.NoError(t, err)
+	assert.Equal(t, []string{" leading.txt", "README.md", "api/main.go", "web/static/my page.html", "web/trailing.txt "}, files)
+
+	files, err = repo.ChangedFiles("main", "feature", "web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web/static/my page.html", "web/trailing.txt "}, files)
+
+	dirs, err := repo.ChangedDirs("main", "feature")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "web"}, dirs)
+
+	runGit(t, dir, "checkout", "-q", "feature")
+	write("go.mod", "module root")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "root module")
+
+	dirs, err = repo.ChangedDirs("main", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{".", "api", "web"}, dirs)
+
+	_, err = repo.ChangedFiles("missing", "")
+	assert.Error(t, err)
+}
@@ -3,9 +3,7 @@ package git
 import (
 	"os"
 	"path/filepath"
-	"strings"
 
-	"github.com/denkhaus/logging"
 	"github.com/denkhaus/magelib"
 
 	"github.com/magefile/mage/sh"
@@ -21,8 +19,10 @@ var (
 	ErrCommitNotDefined = errors.New("commit not defined")
 )
 
-func GitStatus(path string) (*StatusInfo, error) {
-	gitOut, err := GitStatusOutput(path)
+// GitStatus parses the porcelain status of the repository at path.
+// Malformed status output is returned as error.
+func GitStatus(path string, opts ...StatusOption) (*StatusInfo, error) {
+	gitOut, err := GitStatusOutput(path, opts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "GitStatusOutput")
 	}
@@ -32,6 +32,10 @@ func GitStatus(path string) (*StatusInfo, error) {
 		return nil, errors.Wrap(err, "ParseStatusOutput")
 	}
 
+	if err := info.resolveRemote(); err != nil {
+		return nil, errors.Wrap(err, "resolveRemote")
+	}
+
 	return info, nil
 }
 
@@ -41,6 +45,10 @@ func GitStatus(path string) (*StatusInfo, error) {
 // status is the status information of the repository.
 // Returns an error if the repository status is not valid, otherwise nil.
 func FormatStatusError(path string, status *StatusInfo) error {
+	if status.HasDirtySubmodules() {
+		return errors.Errorf("submodules have been changed in repo %q", path)
+	}
+
 	if status.IsDirty() {
 		return errors.Errorf("unstaged files have been changed in repo %q", path)
 	}
@@ -78,20 +86,7 @@ func EnsureBranchInRepositoryCmd(path string, branchName string) magelib.Cmd {
 //
 // It returns an error if there was a problem ensuring the branch.
 func EnsureBranchInRepository(path string, branchName string) error {
-	return magelib.InDirectory(path, func() error {
-		branch, err := Branch()
-		if err != nil {
-			return errors.Wrap(err, "GitBranch")
-		}
-
-		if branch != branchName {
-			logging.Infof("checkout [%s] in repository [%s]", branchName, path)
-			return Checkout(branchName)
-		}
-
-		logging.Infof("branch [%s] is checked out in repository [%s]", branchName, path)
-		return nil
-	})
+	return Repo(path).EnsureBranch(branchName)
 }
 
 func IsRepoCleanCmd(path string) magelib.Cmd {
@@ -114,73 +109,34 @@ func IsRepoClean(path string) error {
 	return FormatStatusError(path, status)
 }
 
-// CurrentCommit retrieves the current commit hash of the Git repository.
+// CurrentCommit retrieves the current commit hash of the Git repository in the process working directory.
 //
 // It returns the commit hash as a string and an error if the command fails.
-func CurrentCommit() (commit string, err error) {
-	commit, err = sh.Output("git", "rev-parse", "HEAD")
-	if err != nil {
-		err = errors.Wrap(err, "git [rev-parse]")
-	}
-	return
+// Use Repo(path).CurrentCommit for other repositories.
+func CurrentCommit() (string, error) {
+	return Repo("").CurrentCommit()
 }
 
-// TagsByCommit retrieves the tags associated with a given commit in a Git repository.
+// TagsByCommit retrieves the tags containing a given commit in the Git repository in the process working directory.
 //
 // It takes a commit string as a parameter and returns a slice of strings representing the tags and an error.
 // The commit string should not be empty. If it is, the function returns an error with the value ErrCommitNotDefined.
-// The function executes the "git tag --contains <commit> --sort=-creatordate" command to retrieve the tags.
-// If the command fails, the function returns an error.
-// The function splits the output of the command into tags using the newline character as a delimiter.
-// If the output contains only an empty string, the function returns an empty slice.
-// Otherwise, it returns the slice of tags.
+// The tags are sorted by creation date, newest first. If no tag contains the commit, the function returns an empty slice.
+// Use Repo(path).TagsContaining for other repositories.
 func TagsByCommit(commit string) ([]string, error) {
-	if commit == "" {
-		return nil, ErrCommitNotDefined
-	}
-
-	output, err := sh.Output("git", "tag", "--contains", commit, "--sort=-creatordate")
-	if err != nil {
-		return nil, errors.Wrap(err, "git [tag --contains]")
-	}
-
-	tags := strings.Split(output, "\n")
-	if len(tags) == 1 && tags[0] == "" {
-		return nil, nil
-	}
-
-	return tags, nil
+	return Repo("").TagsContaining(commit)
 }
 
-// IsCommitTagged checks if a commit is tagged in a Git repository.
+// IsCommitTagged checks if a commit is contained in a tag of the Git repository in the process working directory.
 //
 // It takes a commit hash string as a parameter and returns a boolean indicating whether the commit is tagged and an error.
 func IsCommitTagged(commit string) (bool, error) {
-	currentCommit, err := CurrentCommit()
-	if err != nil {
-		return false, errors.Wrap(err, "CurrentCommit")
-	}
-
-	tags, err := TagsByCommit(currentCommit)
-	if err != nil {
-		return false, errors.Wrap(err, "TagsByCommit")
-	}
-
-	if len(tags) > 0 {
-		return true, nil
-	}
-
-	return false, nil
+	return Repo("").IsCommitTagged(commit)
 }
 
-// MostRecentTag retrieves the most recent tag in a Git repository.
+// MostRecentTag retrieves the most recent tag reachable from HEAD in the Git repository in the process working directory.
 //
 // It returns the tag string and an error if any occurred.
-func MostRecentTag() (tag string, err error) {
-	tag, err = sh.Output("git", "describe", "--tags", "--abbrev=0")
-	if err != nil {
-		err = errors.Wrap(err, "git [describe --tags]")
-	}
-
-	return
+func MostRecentTag() (string, error) {
+	return Repo("").MostRecentTag("HEAD")
 }
@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/git"
+	"github.com/pkg/errors"
+)
+
+// DefaultCommitTypes are the conventional commit types accepted by ValidateCommitMessage.
+var DefaultCommitTypes = []string{
+	"build", "chore", "ci", "docs", "feat", "fix", "perf", "refactor", "revert", "style", "test",
+}
+
+// MaxSubjectLength is the maximum length of the commit header accepted by ValidateCommitMessage.
+var MaxSubjectLength = 72
+
+// skippedPrefixes mark messages generated by git, which are not validated.
+var skippedPrefixes = []string{"Merge ", "Revert \"", "fixup! ", "squash! ", "amend! "}
+
+// ValidateCommitMessageFileCmd as magelib.Cmd
+func ValidateCommitMessageFileCmd(path string, types ...string) magelib.Cmd {
+	return func() error {
+		return ValidateCommitMessageFile(path, types...)
+	}
+}
+
+// ValidateCommitMessageFile validates the commit message file git passes to the commit-msg hook.
+//
+// Parameters:
+// - path: the path of the message file.
+// - types: the allowed commit types. DefaultCommitTypes if empty.
+//
+// Returns:
+// - error: an error describing why the message is rejected.
+func ValidateCommitMessageFile(path string, types ...string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "ReadFile")
+	}
+
+	return ValidateCommitMessage(string(content), types...)
+}
+
+// ValidateCommitMessage checks that message follows the conventional commit format `type(scope)!: subject`.
+// Comment lines are ignored, merge, revert, fixup and squash messages generated by git are accepted.
+//
+// Parameters:
+// - message: the commit message.
+// - types: the allowed commit types. DefaultCommitTypes if empty.
+//
+// Returns:
+// - error: an error describing why the message is rejected.
+func ValidateCommitMessage(message string, types ...string) error {
+	message = stripComments(message)
+	if strings.TrimSpace(message) == "" {
+		return errors.New("commit message is empty")
+	}
+
+	header := strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+	for _, prefix := range skippedPrefixes {
+		if strings.HasPrefix(header, prefix) {
+			return nil
+		}
+	}
+
+	commit, ok := git.ParseConventionalCommit("", message)
+	if !ok {
+		return errors.Errorf("commit header %q doesn't match `type(scope): subject`", header)
+	}
+
+	if len(types) == 0 {
+		types = DefaultCommitTypes
+	}
+
+	if !contains(types, commit.Type) {
+		return errors.Errorf("commit type %q is not one of [%s]", commit.Type, strings.Join(types, ", "))
+	}
+
+	if len(header) > MaxSubjectLength {
+		return errors.Errorf("commit header is %d characters long, at most %d are allowed", len(header), MaxSubjectLength)
+	}
+
+	return nil
+}
+
+func stripComments(message string) string {
+	lines := []string{}
+	for _, line := range strings.Split(message, "\n") {
+		// everything below the scissors line is the diff of `git commit -v`
+		if strings.HasPrefix(line, "# ------------------------ >8 ------------------------") {
+			break
+		}
+
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,288 @@
+// Package hooks installs git hooks that call back into mage targets,
+// so the same checks run locally and in CI.
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/git"
+	"github.com/pkg/errors"
+)
+
+// managedMarker identifies hook scripts written by this package.
+const managedMarker = "# managed by magelib/git/hooks, do not edit"
+
+// backupSuffix is appended to unmanaged hooks replaced by Install.
+const backupSuffix = ".backup"
+
+// Hook maps a git hook to the mage target it runs. The hook arguments are passed on to the target.
+type Hook struct {
+	Name   string
+	Target string
+}
+
+// DefaultHooks runs the targets of a mage `Hooks` namespace.
+var DefaultHooks = []Hook{
+	{Name: "pre-commit", Target: "hooks:preCommit"},
+	{Name: "commit-msg", Target: "hooks:commitMsg"},
+	{Name: "pre-push", Target: "hooks:prePush"},
+}
+
+// MageBinary is the mage executable called by the hook scripts.
+var MageBinary = "mage"
+
+// Script returns the content of the hook script running the target of hook.
+func (h Hook) Script() string {
+	return fmt.Sprintf("#!/bin/sh\n%s\nexec %s %s \"$@\"\n", managedMarker, MageBinary, h.Target)
+}
+
+// InstallCmd as magelib.Cmd
+func InstallCmd(cwd string, hooks ...Hook) magelib.Cmd {
+	return func() error {
+		return Install(cwd, hooks...)
+	}
+}
+
+// Install writes the hook scripts into the hooks directory of the repository at cwd.
+// An existing hook not managed by this package is kept as `<name>.backup` and restored by Uninstall.
+//
+// Parameters:
+// - cwd: a path inside the repository.
+// - hooks: the hooks to install. DefaultHooks if empty.
+//
+// Returns:
+// - error: an error if the hooks directory can't be found or written.
+func Install(cwd string, hooks ...Hook) error {
+	dir, err := hooksDir(cwd)
+	if err != nil {
+		return errors.Wrap(err, "hooksDir")
+	}
+
+	if len(hooks) == 0 {
+		hooks = DefaultHooks
+	}
+
+	for _, hook := range hooks {
+		path := filepath.Join(dir, hook.Name)
+
+		managed, err := isManaged(path)
+		if err != nil {
+			return errors.Wrap(err, "isManaged")
+		}
+
+		if !managed {
+			if _, err := os.Stat(path); err == nil {
+				if _, err := os.Stat(path + backupSuffix); err == nil {
+					return errors.Errorf("hook %q and its backup exist, remove one of them", hook.Name)
+				}
+
+				logging.Infof("backup existing hook [%s] to [%s]", hook.Name, hook.Name+backupSuffix)
+				if err := os.Rename(path, path+backupSuffix); err != nil {
+					return errors.Wrap(err, "Rename")
+				}
+			}
+		}
+
+		if err := writeHook(path, hook); err != nil {
+			return errors.Wrap(err, "writeHook")
+		}
+
+		logging.Infof("installed hook [%s] -> mage %s", hook.Name, hook.Target)
+	}
+
+	return nil
+}
+
+// UninstallCmd as magelib.Cmd
+func UninstallCmd(cwd string) magelib.Cmd {
+	return func() error {
+		return Uninstall(cwd)
+	}
+}
+
+// Uninstall removes all managed hooks of the repository at cwd and restores backed up hooks.
+func Uninstall(cwd string) error {
+	dir, err := hooksDir(cwd)
+	if err != nil {
+		return errors.Wrap(err, "hooksDir")
+	}
+
+	names, err := Installed(cwd)
+	if err != nil {
+		return errors.Wrap(err, "Installed")
+	}
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return errors.Wrap(err, "Remove")
+		}
+
+		if _, err := os.Stat(path + backupSuffix); err == nil {
+			if err := os.Rename(path+backupSuffix, path); err != nil {
+				return errors.Wrap(err, "Rename")
+			}
+		}
+
+		logging.Infof("uninstalled hook [%s]", name)
+	}
+
+	return nil
+}
+
+// RepairCmd as magelib.Cmd
+func RepairCmd(cwd string, hooks ...Hook) magelib.Cmd {
+	return func() error {
+		return Repair(cwd, hooks...)
+	}
+}
+
+// Repair rewrites missing, modified or non executable hooks and removes managed hooks not in hooks.
+//
+// Parameters:
+// - cwd: a path inside the repository.
+// - hooks: the hooks that should be installed. DefaultHooks if empty.
+//
+// Returns:
+// - error: an error if the hooks directory can't be read or written.
+func Repair(cwd string, hooks ...Hook) error {
+	dir, err := hooksDir(cwd)
+	if err != nil {
+		return errors.Wrap(err, "hooksDir")
+	}
+
+	if len(hooks) == 0 {
+		hooks = DefaultHooks
+	}
+
+	wanted := map[string]bool{}
+	for _, hook := range hooks {
+		wanted[hook.Name] = true
+		path := filepath.Join(dir, hook.Name)
+
+		ok, err := isIntact(path, hook)
+		if err != nil {
+			return errors.Wrap(err, "isIntact")
+		}
+
+		if !ok {
+			if err := Install(cwd, hook); err != nil {
+				return errors.Wrap(err, "Install")
+			}
+		}
+	}
+
+	names, err := Installed(cwd)
+	if err != nil {
+		return errors.Wrap(err, "Installed")
+	}
+
+	for _, name := range names {
+		if wanted[name] {
+			continue
+		}
+
+		logging.Infof("remove stale hook [%s]", name)
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return errors.Wrap(err, "Remove")
+		}
+	}
+
+	return nil
+}
+
+// Installed returns the names of the managed hooks of the repository at cwd.
+func Installed(cwd string) ([]string, error) {
+	dir, err := hooksDir(cwd)
+	if err != nil {
+		return nil, errors.Wrap(err, "hooksDir")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "ReadDir")
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		managed, err := isManaged(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "isManaged")
+		}
+
+		if managed {
+			names = append(names, file.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func hooksDir(cwd string) (string, error) {
+	gitDir, err := git.PathToGitDir(cwd)
+	if err != nil {
+		return "", errors.Wrap(err, "PathToGitDir")
+	}
+
+	return filepath.Join(gitDir, "hooks"), nil
+}
+
+func writeHook(path string, hook Hook) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "MkdirAll")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(hook.Script()), 0755); err != nil {
+		return errors.Wrap(err, "WriteFile")
+	}
+
+	// WriteFile doesn't change the mode of existing files
+	return os.Chmod(path, 0755)
+}
+
+func isManaged(path string) (bool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "ReadFile")
+	}
+
+	return bytes.Contains(content, []byte(managedMarker)), nil
+}
+
+func isIntact(path string, hook Hook) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "Stat")
+	}
+
+	if info.Mode()&0111 == 0 {
+		return false, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, errors.Wrap(err, "ReadFile")
+	}
+
+	return strings.TrimSpace(string(content)) == strings.TrimSpace(hook.Script()), nil
+}
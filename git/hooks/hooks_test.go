@@ -0,0 +1,116 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestInstallRepairUninstall(t *testing.T) {
+	dir := t.TempDir()
+	_, err := runGit(t, dir, "init", "-q")
+	require.NoError(t, err)
+
+	hooksPath := filepath.Join(dir, ".git", "hooks")
+	require.NoError(t, os.MkdirAll(hooksPath, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(hooksPath, "pre-commit"), []byte("#!/bin/sh\nexit 0\n"), 0755))
+
+	// a fake mage records the target and validates commit messages like the built-in validator
+	bin := t.TempDir()
+	log := filepath.Join(bin, "calls")
+	fakeMage := "#!/bin/sh\necho \"$@\" >> " + log + "\n" +
+		"if [ \"$1\" = hooks:commitMsg ]; then grep -q '^feat: ' \"$2\"; fi\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(bin, "mage"), []byte(fakeMage), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.NoError(t, Install(dir))
+
+	names, err := Installed(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"commit-msg", "pre-commit", "pre-push"}, names)
+	assert.FileExists(t, filepath.Join(hooksPath, "pre-commit.backup"))
+
+	out, err := runGit(t, dir, "commit", "--allow-empty", "-m", "not conventional")
+	assert.Error(t, err, out)
+
+	out, err = runGit(t, dir, "commit", "--allow-empty", "-m", "feat: conventional")
+	assert.NoError(t, err, out)
+
+	calls, err := ioutil.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"hooks:preCommit",
+		"hooks:commitMsg .git/COMMIT_EDITMSG",
+		"hooks:preCommit",
+		"hooks:commitMsg .git/COMMIT_EDITMSG",
+	}, strings.Split(strings.TrimSpace(string(calls)), "\n"))
+
+	require.NoError(t, os.Chmod(filepath.Join(hooksPath, "commit-msg"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(hooksPath, "pre-push"), []byte(managedMarker+"\n"), 0755))
+	require.NoError(t, Repair(dir, DefaultHooks[:2]...))
+
+	names, err = Installed(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"commit-msg", "pre-commit"}, names)
+
+	info, err := os.Stat(filepath.Join(hooksPath, "commit-msg"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	require.NoError(t, Uninstall(dir))
+
+	names, err = Installed(dir)
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	content, err := ioutil.ReadFile(filepath.Join(hooksPath, "pre-commit"))
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\nexit 0\n", string(content))
+	assert.NoFileExists(t, filepath.Join(hooksPath, "pre-commit.backup"))
+}
+
+func TestValidateCommitMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		valid   bool
+	}{
+		{"feat(git): add hooks\n", true},
+		{"fix!: breaking fix\n\nBREAKING CHANGE: details", true},
+		{"# comment\nchore: tidy\n# Please enter the commit message\n", true},
+		{"Merge branch 'main' into feature", true},
+		{"fixup! feat: add hooks", true},
+		{"added stuff", false},
+		{"feature: unknown type", false},
+		{"feat: " + strings.Repeat("x", 80), false},
+		{"# only comments\n", false},
+	}
+
+	for _, test := range tests {
+		err := ValidateCommitMessage(test.message)
+		if test.valid {
+			assert.NoError(t, err, test.message)
+		} else {
+			assert.Error(t, err, test.message)
+		}
+	}
+
+	assert.NoError(t, ValidateCommitMessage("wip: custom type", "wip"))
+}
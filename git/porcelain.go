@@ -3,11 +3,12 @@ package git
 // based on https://github.com/robertgzr/porcelain.git
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
@@ -19,6 +20,39 @@ const notRepoStatus string = "exit status 128"
 
 var ErrNotAGitRepo = errors.New("not a git repo")
 
+var (
+	submoduleState = regexp.MustCompile(`^(N\.\.\.|S[C.][M.][U.])$`)
+	objectName     = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+	renameScore    = regexp.MustCompile(`^[RC][0-9]{1,3}$`)
+)
+
+// StatusEntry is a changed, unmerged, untracked or ignored path reported by git status.
+type StatusEntry struct {
+	// Kind is the record type, '1' changed, '2' renamed or copied, 'u' unmerged, '?' untracked or '!' ignored.
+	Kind byte
+	// XY is the staged and unstaged state, `.` means unchanged.
+	XY string
+	// Submodule is `N...` for regular files or `S<c><m><u>` for submodules.
+	Submodule string
+	Path      string
+	// OrigPath is the source path of renamed or copied entries.
+	OrigPath string
+}
+
+type statusConfig struct {
+	ignored bool
+}
+
+// StatusOption configures GitStatus.
+type StatusOption func(*statusConfig)
+
+// WithIgnored reports ignored paths as well.
+func WithIgnored() StatusOption {
+	return func(c *statusConfig) {
+		c.ignored = true
+	}
+}
+
 type GitArea struct {
 	modified int
 	added    int
@@ -54,10 +88,13 @@ type StatusInfo struct {
 	remote     string
 	upstream   string
 
-	ahead     int
-	behind    int
-	untracked int
-	unmerged  int
+	ahead      int
+	behind     int
+	untracked  int
+	unmerged   int
+	ignored    int
+	submodules int
+	entries    []StatusEntry
 
 	Unstaged GitArea
 	Staged   GitArea
@@ -81,6 +118,21 @@ func (pi *StatusInfo) IsDirty() bool {
 	return pi.Staged.hasChanged()
 }
 
+// HasDirtySubmodules returns true if a submodule has a changed commit, tracked changes or untracked files
+func (pi *StatusInfo) HasDirtySubmodules() bool {
+	return pi.submodules > 0
+}
+
+// Upstream returns the remote branch tracked by the current branch, empty if there is none.
+func (pi *StatusInfo) Upstream() string {
+	return pi.upstream
+}
+
+// Remote returns the remote of the upstream branch, empty if there is none or the upstream is a local branch.
+func (pi *StatusInfo) Remote() string {
+	return pi.remote
+}
+
 // IsSynced returns true if repo is in sync with remote
 func (pi *StatusInfo) IsSynced() bool {
 	return pi.ahead == 0 && pi.behind == 0
@@ -91,144 +143,283 @@ func (pi *StatusInfo) Debug() string {
 	return fmt.Sprintf("%#+v", pi)
 }
 
+// Entries returns the changed, unmerged, untracked and ignored paths in the order reported by git.
+func (pi *StatusInfo) Entries() []StatusEntry {
+	return pi.entries
+}
+
+// Ignored returns the number of ignored paths. Ignored paths are only reported with WithIgnored.
+func (pi *StatusInfo) Ignored() int {
+	return pi.ignored
+}
+
+// parseStatusOutput parses the NUL terminated records of `git status --porcelain=v2 -z`
+// doc: https://git-scm.com/docs/git-status#_porcelain_format_version_2
 func (pi *StatusInfo) parseStatusOutput(r io.Reader) error {
-	var s = bufio.NewScanner(r)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "ReadAll")
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
 
-	for s.Scan() {
-		if len(s.Text()) < 1 {
-			continue
+	if out[len(out)-1] != 0 {
+		return errors.New("status output is not NUL terminated")
+	}
+
+	records := strings.Split(string(out[:len(out)-1]), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if record == "" {
+			return errors.Errorf("empty status record at position %d", i)
 		}
 
-		pi.parseLine(s.Text())
+		var err error
+		switch record[0] {
+		case '#':
+			err = pi.parseBranchInfo(record)
+		case '1':
+			err = pi.parseTrackedFile(record)
+		case '2':
+			// the original path of renamed and copied entries follows as separate record
+			if i+1 >= len(records) {
+				return errors.Errorf("missing original path of status record %q", record)
+			}
+			i++
+			err = pi.parseRenamedFile(record, records[i])
+		case 'u':
+			err = pi.parseUnmergedFile(record)
+		case '?', '!':
+			err = pi.parseUntrackedFile(record)
+		default:
+			err = errors.New("unknown record type")
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "invalid status record %q", record)
+		}
 	}
 
 	return nil
 }
 
-func (pi *StatusInfo) parseLine(line string) error {
-	s := bufio.NewScanner(strings.NewReader(line))
-	// switch to a word based scanner
-	s.Split(bufio.ScanWords)
-
-	for s.Scan() {
-		switch s.Text() {
-		case "#":
-			pi.parseBranchInfo(s)
-		case "1":
-			pi.parseTrackedFile(s)
-		case "2":
-			pi.parseRenamedFile(s)
-		case "u":
-			pi.unmerged++
-		case "?":
-			pi.untracked++
-		}
+func (pi *StatusInfo) parseBranchInfo(record string) error {
+	fields := strings.SplitN(record, " ", 3)
+	if len(fields) != 3 || fields[0] != "#" {
+		return errors.New("malformed header")
 	}
+
+	switch fields[1] {
+	case "branch.oid":
+		pi.commit = fields[2]
+	case "branch.head":
+		pi.branch = fields[2]
+	case "branch.upstream":
+		// the remote can't be derived from the upstream name, see resolveRemote
+		pi.upstream = fields[2]
+	case "branch.ab":
+		return pi.parseAheadBehind(fields[2])
+	}
+
+	// unknown headers like `# stash` are skipped as documented
 	return nil
 }
 
-func (pi *StatusInfo) parseBranchInfo(s *bufio.Scanner) (err error) {
-	// uses the word based scanner from ParseLine
-	for s.Scan() {
-		switch s.Text() {
-		case "branch.oid":
-			pi.commit = consumeNext(s)
-		case "branch.head":
-			pi.branch = consumeNext(s)
-		case "branch.upstream":
-			pi.upstream = consumeNext(s)
-		case "branch.ab":
-			err = pi.parseAheadBehind(s)
-		}
+// resolveRemote sets the remote of the upstream from `branch.<name>.remote`. The upstream name
+// doesn't tell it, remote names may contain slashes and local upstreams have the remote `.`.
+func (pi *StatusInfo) resolveRemote() error {
+	if pi.upstream == "" || pi.branch == "(detached)" {
+		return nil
+	}
+
+	remote, err := gitConfigValue(pi.workingDir, "branch."+pi.branch+".remote")
+	if err != nil {
+		return errors.Wrap(err, "gitConfigValue")
 	}
-	return err
+
+	if remote != "." {
+		pi.remote = remote
+	}
+
+	return nil
 }
 
-func (pi *StatusInfo) parseAheadBehind(s *bufio.Scanner) error {
-	// uses the word based scanner from ParseLine
-	for s.Scan() {
-		i, err := strconv.Atoi(s.Text()[1:])
-		if err != nil {
-			return err
-		}
+func (pi *StatusInfo) parseAheadBehind(ab string) error {
+	fields := strings.Split(ab, " ")
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "+") || !strings.HasPrefix(fields[1], "-") {
+		return errors.Errorf("malformed ahead/behind %q", ab)
+	}
 
-		switch s.Text()[:1] {
-		case "+":
-			pi.ahead = i
-		case "-":
-			pi.behind = i
-		}
+	ahead, err := strconv.Atoi(fields[0][1:])
+	if err != nil {
+		return errors.Wrap(err, "Atoi")
+	}
+
+	behind, err := strconv.Atoi(fields[1][1:])
+	if err != nil {
+		return errors.Wrap(err, "Atoi")
+	}
+
+	if ahead < 0 || behind < 0 {
+		return errors.Errorf("malformed ahead/behind %q", ab)
 	}
+
+	pi.ahead, pi.behind = ahead, behind
 	return nil
 }
 
 // parseTrackedFile parses the porcelain v2 output for tracked entries
+// `1 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <path>`
 // doc: https://git-scm.com/docs/git-status#_changed_tracked_entries
-func (pi *StatusInfo) parseTrackedFile(s *bufio.Scanner) error {
-	// uses the word based scanner from ParseLine
-	var index int
-	for s.Scan() {
-		switch index {
-		case 0: // xy
-			pi.parseXY(s.Text())
-		default:
-			continue
-			// case 1: // sub
-			// 	if s.Text() != "N..." {
-			// 		log.Println("is submodule!!!")
-			// 	}
-			// case 2: // mH - octal file mode in HEAD
-			// 	log.Println(index, s.Text())
-			// case 3: // mI - octal file mode in index
-			// 	log.Println(index, s.Text())
-			// case 4: // mW - octal file mode in worktree
-			// 	log.Println(index, s.Text())
-			// case 5: // hH - object name in HEAD
-			// 	log.Println(index, s.Text())
-			// case 6: // hI - object name in index
-			// 	log.Println(index, s.Text())
-			// case 7: // path
-			// 	log.Println(index, s.Text())
+func (pi *StatusInfo) parseTrackedFile(record string) error {
+	fields := strings.SplitN(record, " ", 9)
+	if len(fields) != 9 {
+		return errors.New("unexpected number of fields")
+	}
+
+	entry := StatusEntry{Kind: '1', XY: fields[1], Submodule: fields[2], Path: fields[8]}
+	if err := pi.addEntry(entry, fields[3:6], fields[6:8]); err != nil {
+		return err
+	}
+
+	return pi.parseXY(entry.XY)
+}
+
+// parseRenamedFile parses renamed or copied entries
+// `2 <XY> <sub> <mH> <mI> <mW> <hH> <hI> <X><score> <path>` followed by the original path
+func (pi *StatusInfo) parseRenamedFile(record, origPath string) error {
+	fields := strings.SplitN(record, " ", 10)
+	if len(fields) != 10 {
+		return errors.New("unexpected number of fields")
+	}
+
+	if !renameScore.MatchString(fields[8]) {
+		return errors.Errorf("malformed rename score %q", fields[8])
+	}
+
+	if origPath == "" {
+		return errors.New("empty original path")
+	}
+
+	entry := StatusEntry{Kind: '2', XY: fields[1], Submodule: fields[2], Path: fields[9], OrigPath: origPath}
+	if err := pi.addEntry(entry, fields[3:6], fields[6:8]); err != nil {
+		return err
+	}
+
+	return pi.parseXY(entry.XY)
+}
+
+// parseUnmergedFile parses unmerged entries
+// `u <XY> <sub> <m1> <m2> <m3> <mW> <h1> <h2> <h3> <path>`
+func (pi *StatusInfo) parseUnmergedFile(record string) error {
+	fields := strings.SplitN(record, " ", 11)
+	if len(fields) != 11 {
+		return errors.New("unexpected number of fields")
+	}
+
+	entry := StatusEntry{Kind: 'u', XY: fields[1], Submodule: fields[2], Path: fields[10]}
+	if err := pi.addEntry(entry, fields[3:7], fields[7:10]); err != nil {
+		return err
+	}
+
+	pi.unmerged++
+	return nil
+}
+
+// parseUntrackedFile parses untracked `? <path>` and ignored `! <path>` entries
+func (pi *StatusInfo) parseUntrackedFile(record string) error {
+	if len(record) < 3 || record[1] != ' ' {
+		return errors.New("malformed path")
+	}
+
+	pi.entries = append(pi.entries, StatusEntry{Kind: record[0], XY: "..", Path: record[2:]})
+	if record[0] == '?' {
+		pi.untracked++
+	} else {
+		pi.ignored++
+	}
+
+	return nil
+}
+
+// addEntry validates the common fields of tracked and unmerged entries and records the entry.
+func (pi *StatusInfo) addEntry(entry StatusEntry, modes, hashes []string) error {
+	if len(entry.XY) != 2 || strings.Trim(entry.XY, ".MTADRCU") != "" {
+		return errors.Errorf("malformed XY %q", entry.XY)
+	}
+
+	if !submoduleState.MatchString(entry.Submodule) {
+		return errors.Errorf("malformed submodule state %q", entry.Submodule)
+	}
+
+	for _, mode := range modes {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			return errors.Errorf("malformed file mode %q", mode)
+		}
+	}
+
+	for _, hash := range hashes {
+		if !objectName.MatchString(hash) {
+			return errors.Errorf("malformed object name %q", hash)
 		}
-		index++
 	}
+
+	if entry.Path == "" {
+		return errors.New("empty path")
+	}
+
+	if entry.Submodule != "N..." && strings.ContainsAny(entry.Submodule[1:], "CMU") {
+		pi.submodules++
+	}
+
+	pi.entries = append(pi.entries, entry)
 	return nil
 }
 
 func (pi *StatusInfo) parseXY(xy string) error {
-	switch xy[:1] { // parse staged
-	case "M":
+	switch xy[0] { // parse staged
+	case 'M', 'T':
 		pi.Staged.modified++
-	case "A":
+	case 'A':
 		pi.Staged.added++
-	case "D":
+	case 'D':
 		pi.Staged.deleted++
-	case "R":
+	case 'R':
 		pi.Staged.renamed++
-	case "C":
+	case 'C':
 		pi.Staged.copied++
+	case '.':
+	default:
+		return errors.Errorf("unexpected staged state %q", xy[0])
 	}
 
-	switch xy[1:] { // parse unstaged
-	case "M":
+	switch xy[1] { // parse unstaged
+	case 'M', 'T':
 		pi.Unstaged.modified++
-	case "A":
+	case 'A':
 		pi.Unstaged.added++
-	case "D":
+	case 'D':
 		pi.Unstaged.deleted++
-	case "R":
+	case 'R':
 		pi.Unstaged.renamed++
-	case "C":
+	case 'C':
 		pi.Unstaged.copied++
+	case '.':
+	default:
+		return errors.Errorf("unexpected unstaged state %q", xy[1])
 	}
+
 	return nil
 }
 
-func (pi *StatusInfo) parseRenamedFile(s *bufio.Scanner) error {
-	return pi.parseTrackedFile(s)
-}
+func GitStatusOutput(cwd string, opts ...StatusOption) (io.Reader, error) {
+	cfg := statusConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-func GitStatusOutput(cwd string) (io.Reader, error) {
 	if ok, err := IsInsideWorkTree(cwd); err != nil {
 		if err == ErrNotAGitRepo {
 			return nil, ErrNotAGitRepo
@@ -241,7 +432,12 @@ func GitStatusOutput(cwd string) (io.Reader, error) {
 	var stderr = new(bytes.Buffer)
 	var stdout = new(bytes.Buffer)
 
-	cmd := exec.Command("git", "status", "--porcelain=v2", "--branch")
+	args := []string{"status", "--porcelain=v2", "--branch", "-z"}
+	if cfg.ignored {
+		args = append(args, "--ignored")
+	}
+
+	cmd := exec.Command("git", args...)
 
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
@@ -249,7 +445,8 @@ func GitStatusOutput(cwd string) (io.Reader, error) {
 
 	if err := cmd.Run(); err != nil {
 		return nil, errors.Wrapf(err,
-			"git status --porcelain=v2 --branch err: [%s]",
+			"git %s err: [%s]",
+			strings.Join(args, " "),
 			stderr.String(),
 		)
 	}
@@ -300,10 +497,3 @@ func IsInsideWorkTree(cwd string) (bool, error) {
 
 	return strconv.ParseBool(strings.TrimSpace(string(out)))
 }
-
-func consumeNext(s *bufio.Scanner) string {
-	if s.Scan() {
-		return s.Text()
-	}
-	return ""
-}
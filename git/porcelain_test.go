@@ -0,0 +1,130 @@
+package git
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// statusSummary renders the parsed status for comparison with the golden files.
+func statusSummary(pi *StatusInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "branch=%s commit=%s upstream=%s ahead=%d behind=%d\n",
+		pi.branch, pi.commit, pi.Upstream(), pi.ahead, pi.behind)
+	fmt.Fprintf(&b, "untracked=%d unmerged=%d ignored=%d submodules=%d\n",
+		pi.untracked, pi.unmerged, pi.ignored, pi.submodules)
+	fmt.Fprintf(&b, "staged=%+v\nunstaged=%+v\n", pi.Staged, pi.Unstaged)
+
+	for _, e := range pi.Entries() {
+		fmt.Fprintf(&b, "%c %s %s %q %q\n", e.Kind, e.XY, e.Submodule, e.Path, e.OrigPath)
+	}
+
+	return b.String()
+}
+
+// TestParseStatusOutputGolden parses recorded `git status --porcelain=v2 --branch -z` outputs.
+// Run with -update to rewrite the golden files.
+func TestParseStatusOutputGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "porcelain", "*.status"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".status")
+		t.Run(name, func(t *testing.T) {
+			out, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+
+			pi := NewStatusInfo("")
+			require.NoError(t, pi.parseStatusOutput(bytes.NewReader(out)))
+
+			golden := strings.TrimSuffix(file, ".status") + ".golden"
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, []byte(statusSummary(pi)), 0644))
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), statusSummary(pi))
+		})
+	}
+}
+
+func TestParseStatusOutputMalformed(t *testing.T) {
+	const hash = "c1827f07e114c20547dc6a7296588870a4b5b62c"
+	tests := map[string]string{
+		"not terminated":    "? file",
+		"empty record":      "? a\x00\x00? b\x00",
+		"unknown type":      "x file\x00",
+		"ahead behind":      "# branch.ab +1\x00",
+		"ahead behind sign": "# branch.ab 1 -1\x00",
+		"ahead behind int":  "# branch.ab +a -1\x00",
+		"short header":      "# branch.oid\x00",
+		"missing fields":    "1 .M N... 100644 100644 100644 " + hash + "\x00",
+		"invalid xy":        "1 .X N... 100644 100644 100644 " + hash + " " + hash + " file\x00",
+		"invalid sub":       "1 .M SX.. 100644 100644 100644 " + hash + " " + hash + " file\x00",
+		"invalid mode":      "1 .M N... 100644 100948 100644 " + hash + " " + hash + " file\x00",
+		"invalid hash":      "1 .M N... 100644 100644 100644 " + hash + " abc file\x00",
+		"empty path":        "1 .M N... 100644 100644 100644 " + hash + " " + hash + " \x00",
+		"rename score":      "2 R. N... 100644 100644 100644 " + hash + " " + hash + " X100 new\x00old\x00",
+		"rename orig path":  "2 R. N... 100644 100644 100644 " + hash + " " + hash + " R100 new\x00",
+		"unmerged fields":   "u UU N... 100644 100644 100644 " + hash + " " + hash + " file\x00",
+		"untracked path":    "?\x00",
+	}
+
+	for name, output := range tests {
+		pi := NewStatusInfo("")
+		assert.Error(t, pi.parseStatusOutput(strings.NewReader(output)), name)
+	}
+}
+
+func TestGitStatusIgnored(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.log\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "debug file.log"), []byte("log"), 0644))
+
+	status, err := GitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Ignored())
+	assert.Equal(t, []StatusEntry{{Kind: '?', XY: "..", Path: ".gitignore"}}, status.Entries())
+
+	status, err = GitStatus(dir, WithIgnored())
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Ignored())
+	assert.Contains(t, status.Entries(), StatusEntry{Kind: '!', XY: "..", Path: "debug file.log"})
+}
+
+func FuzzParseStatusOutput(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "porcelain", "*.status"))
+	require.NoError(f, err)
+
+	for _, file := range files {
+		out, err := ioutil.ReadFile(file)
+		require.NoError(f, err)
+		f.Add(out)
+	}
+
+	f.Fuzz(func(t *testing.T, out []byte) {
+		pi := NewStatusInfo("")
+		if err := pi.parseStatusOutput(bytes.NewReader(out)); err != nil {
+			return
+		}
+
+		// every accepted entry has a path and a valid state
+		for _, e := range pi.Entries() {
+			if e.Path == "" || len(e.XY) != 2 {
+				t.Fatalf("invalid entry %+v", e)
+			}
+		}
+	})
+}
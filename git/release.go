@@ -0,0 +1,206 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/prompt"
+	"github.com/pkg/errors"
+)
+
+// ErrReleaseAborted is returned by Release if the release is not confirmed.
+var ErrReleaseAborted = errors.New("release aborted")
+
+type releaseConfig struct {
+	assumeYes     bool
+	auth          AuthProvider
+	progress      io.Writer
+	versionOpts   []VersionOption
+	changelogOpts []ChangelogOption
+}
+
+// ReleaseOption configures Release.
+type ReleaseOption func(*releaseConfig)
+
+// WithAssumeYes skips the confirmation prompt if yes is true, e.g. for CI or a `--yes` flag.
+func WithAssumeYes(yes bool) ReleaseOption {
+	return func(c *releaseConfig) {
+		c.assumeYes = yes
+	}
+}
+
+// WithReleaseAuth sets the auth provider used to push the tag.
+func WithReleaseAuth(provider AuthProvider) ReleaseOption {
+	return func(c *releaseConfig) {
+		c.auth = provider
+	}
+}
+
+// WithReleaseProgress sets the writer receiving the push progress. Defaults to os.Stdout.
+func WithReleaseProgress(w io.Writer) ReleaseOption {
+	return func(c *releaseConfig) {
+		c.progress = w
+	}
+}
+
+// WithVersionOptions sets the options of the version calculation, e.g. WithChannel.
+func WithVersionOptions(opts ...VersionOption) ReleaseOption {
+	return func(c *releaseConfig) {
+		c.versionOpts = append(c.versionOpts, opts...)
+	}
+}
+
+// WithChangelogOptions sets the options used to render the tag message, e.g. WithIssueURL.
+func WithChangelogOptions(opts ...ChangelogOption) ReleaseOption {
+	return func(c *releaseConfig) {
+		c.changelogOpts = append(c.changelogOpts, opts...)
+	}
+}
+
+// ReleaseCmd as magelib.Cmd
+func ReleaseCmd(path, branch string, opts ...ReleaseOption) magelib.Cmd {
+	return func() error {
+		_, err := Release(context.Background(), path, branch, opts...)
+		return err
+	}
+}
+
+// Release tags the next version of the repository at path and pushes the tag to origin.
+// The repository has to be clean, branch is checked out if necessary and has to be pushed to origin.
+// The version is calculated by NextVersion, the tag is annotated with the changelog since the previous release.
+// If the push fails, the local tag is deleted again.
+//
+// Parameters:
+// - ctx: the context used to cancel the underlying git commands.
+// - path: the path to the repository.
+// - branch: the release branch.
+// - opts: options for confirmation, auth, version and changelog.
+//
+// Returns:
+// - string: the released version.
+// - error: ErrReleaseAborted if the release wasn't confirmed, ErrNoChanges if there is nothing to release.
+func Release(ctx context.Context, path, branch string, opts ...ReleaseOption) (string, error) {
+	cfg := releaseConfig{progress: os.Stdout}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := IsRepoClean(path); err != nil {
+		return "", errors.Wrap(err, "IsRepoClean")
+	}
+
+	if err := EnsureBranchInRepository(path, branch); err != nil {
+		return "", errors.Wrap(err, "EnsureBranchInRepository")
+	}
+
+	var version, changelog string
+	err := magelib.InDirectory(path, func() error {
+		info, err := CalculateVersion(ctx, cfg.versionOpts...)
+		if err != nil {
+			return errors.Wrap(err, "CalculateVersion")
+		}
+		version = info.Next
+
+		changelogOpts := append(cfg.changelogOpts, WithChangelogVersion(version))
+		if info.Previous == "" {
+			// the first release covers the whole history like CalculateVersion, prerelease tags included
+			changelogOpts = append(changelogOpts, WithChangelogFullHistory())
+		}
+
+		changelog, err = Changelog(info.Previous, "HEAD", changelogOpts...)
+		if err != nil {
+			return errors.Wrap(err, "Changelog")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		if errors.Cause(err) == ErrNoChanges {
+			return "", ErrNoChanges
+		}
+		return "", err
+	}
+
+	if err := ensurePushed(path, branch, version); err != nil {
+		return "", errors.Wrap(err, "ensurePushed")
+	}
+
+	if !cfg.assumeYes {
+		fmt.Println(changelog)
+
+		ok, err := prompt.YesNo(fmt.Sprintf("Release %s from branch %s", version, branch))
+		if err != nil {
+			return "", errors.Wrap(err, "YesNo")
+		}
+
+		if !ok {
+			return "", ErrReleaseAborted
+		}
+	}
+
+	repo, err := NewGitRepository(os.ExpandEnv(path), "", WithAuth(cfg.auth))
+	if err != nil {
+		return "", errors.Wrap(err, "NewGitRepository")
+	}
+
+	if err := repo.CreateTag(version, "", changelog); err != nil {
+		return "", errors.Wrap(err, "CreateTag")
+	}
+
+	ref := "refs/tags/" + version
+	if err := repo.Push(cfg.progress, ref+":"+ref); err != nil {
+		logging.Warnf("push of tag [%s] failed, delete local tag", version)
+		if delErr := repo.DeleteTag(version); delErr != nil {
+			return "", errors.Wrapf(err, "Push, rollback failed: %v", delErr)
+		}
+		return "", errors.Wrap(err, "Push")
+	}
+
+	logging.Infof("released [%s] from branch [%s]", version, branch)
+	return version, nil
+}
+
+// ensurePushed checks that the released commit is the tip of branch on origin
+// and that the version isn't tagged on origin yet.
+func ensurePushed(path, branch, version string) error {
+	repo := Repo(path)
+
+	head, err := repo.CurrentCommit()
+	if err != nil {
+		return errors.Wrap(err, "CurrentCommit")
+	}
+
+	branches, err := repo.RemoteBranches(DefaultRemote)
+	if err != nil {
+		return errors.Wrap(err, "RemoteBranches")
+	}
+
+	pushed := false
+	for _, ref := range branches {
+		if ref.Name == branch {
+			pushed = ref.Hash == head
+		}
+	}
+
+	if !pushed {
+		return errors.Errorf("commit %s is not the tip of branch %q on remote %q", head, branch, DefaultRemote)
+	}
+
+	tags, err := repo.RemoteTags(DefaultRemote)
+	if err != nil {
+		return errors.Wrap(err, "RemoteTags")
+	}
+
+	for _, ref := range tags {
+		if ref.Name == version {
+			return errors.Errorf("tag %q already exists on remote %q", version, DefaultRemote)
+		}
+	}
+
+	return nil
+}
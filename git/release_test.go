@@ -0,0 +1,78 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelease(t *testing.T) {
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	runGit(t, filepath.Dir(origin), "init", "-q", "--bare", "-b", "main", origin)
+
+	dir := t.TempDir()
+	runGit(t, dir, "clone", "-q", origin, ".")
+	runGit(t, dir, "checkout", "-q", "-b", "main")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat: initial feature")
+	runGit(t, dir, "tag", "v0.1.0-rc.1")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat: second feature")
+	runGit(t, dir, "push", "-q", "-u", "origin", "main")
+	runGit(t, dir, "checkout", "-q", "-b", "develop")
+
+	version, err := Release(context.Background(), dir, "main", WithAssumeYes(true), WithReleaseProgress(ioutil.Discard))
+	require.NoError(t, err)
+	assert.Equal(t, "v0.1.0", version)
+
+	branch, err := Repo(dir).Branch()
+	require.NoError(t, err)
+	assert.Equal(t, "main", branch)
+
+	message, err := exec.Command("git", "-C", origin, "tag", "-l", "--format=%(contents)", "v0.1.0").Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(message), "## v0.1.0")
+	// the first release lists the commits before the prerelease tag too
+	assert.Contains(t, string(message), "initial feature")
+	assert.Contains(t, string(message), "second feature")
+
+	// nothing to release without new commits
+	_, err = Release(context.Background(), dir, "main", WithAssumeYes(true), WithReleaseProgress(ioutil.Discard))
+	assert.Equal(t, ErrNoChanges, err)
+
+	// unpushed commits are not released
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "fix: a bug")
+	runGit(t, dir, "branch", "--unset-upstream")
+	_, err = Release(context.Background(), dir, "main", WithAssumeYes(true), WithReleaseProgress(ioutil.Discard))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not the tip of branch")
+
+	// the tag is removed again if the push fails
+	runGit(t, dir, "push", "-q", "-u", "origin", "main")
+	hook := filepath.Join(origin, "hooks", "pre-receive")
+	require.NoError(t, ioutil.WriteFile(hook, []byte("#!/bin/sh\necho tags are rejected >&2\nexit 1\n"), 0755))
+
+	_, err = Release(context.Background(), dir, "main", WithAssumeYes(true), WithReleaseProgress(ioutil.Discard))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Push")
+
+	tags, err := Repo(dir).TagsAt("HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	// a dirty repository is not released
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dirty"), []byte("dirty"), 0644))
+	runGit(t, dir, "add", "dirty")
+	_, err = Release(context.Background(), dir, "main", WithAssumeYes(true))
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "IsRepoClean"))
+}
@@ -0,0 +1,182 @@
+package git
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRemote is the remote used by Release.
+const DefaultRemote = "origin"
+
+// ErrNoUpstream is returned by Upstream if the branch doesn't track a remote branch.
+var ErrNoUpstream = errors.New("no upstream configured")
+
+// Remote is a configured remote of a repository.
+type Remote struct {
+	Name      string
+	FetchURLs []string
+	PushURLs  []string
+}
+
+// RemoteRef is a branch or tag of a remote repository.
+type RemoteRef struct {
+	// Name is the short name of the ref, e.g. `main` or `v1.0.0`.
+	Name string
+	// Hash is the object the ref points to, the tag object for annotated tags.
+	Hash string
+}
+
+// Remotes lists the remotes of the repository with their fetch and push URLs.
+func (r *LocalRepo) Remotes() ([]Remote, error) {
+	out, err := r.output("remote", "-v")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [remote -v]")
+	}
+
+	remotes := []Remote{}
+	index := map[string]int{}
+	for _, line := range splitLines(out) {
+		// <name>\t<url> (fetch|push), the url may contain spaces
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid remote %q", line)
+		}
+
+		name, url := fields[0], fields[1]
+		i, ok := index[name]
+		if !ok {
+			i = len(remotes)
+			index[name] = i
+			remotes = append(remotes, Remote{Name: name})
+		}
+
+		switch {
+		case strings.HasSuffix(url, " (fetch)"):
+			remotes[i].FetchURLs = append(remotes[i].FetchURLs, strings.TrimSuffix(url, " (fetch)"))
+		case strings.HasSuffix(url, " (push)"):
+			remotes[i].PushURLs = append(remotes[i].PushURLs, strings.TrimSuffix(url, " (push)"))
+		default:
+			return nil, errors.Errorf("invalid remote %q", line)
+		}
+	}
+
+	return remotes, nil
+}
+
+// Upstream returns the remote branch tracked by branch, e.g. `origin/main`.
+// An empty branch refers to the current branch.
+func (r *LocalRepo) Upstream(branch string) (string, error) {
+	if branch == "" {
+		current, err := r.Branch()
+		if err != nil {
+			return "", errors.Wrap(err, "Branch")
+		}
+		branch = current
+	}
+
+	out, err := r.output("for-each-ref", "--format=%(upstream:short)", "refs/heads/"+branch)
+	if err != nil {
+		return "", errors.Wrap(err, "git [for-each-ref]")
+	}
+
+	if out == "" {
+		return "", ErrNoUpstream
+	}
+
+	return out, nil
+}
+
+// RemoteBranches lists the branches of remote using `git ls-remote`.
+func (r *LocalRepo) RemoteBranches(remote string) ([]RemoteRef, error) {
+	return r.lsRemote(remote, "--heads", "refs/heads/")
+}
+
+// RemoteTags lists the tags of remote using `git ls-remote`.
+func (r *LocalRepo) RemoteTags(remote string) ([]RemoteRef, error) {
+	return r.lsRemote(remote, "--tags", "refs/tags/")
+}
+
+// RemoteOnlyBranches lists the branches of remote without local branch of the same name.
+func (r *LocalRepo) RemoteOnlyBranches(remote string) ([]RemoteRef, error) {
+	refs, err := r.RemoteBranches(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "RemoteBranches")
+	}
+
+	return r.withoutLocal(refs, "refs/heads")
+}
+
+// RemoteOnlyTags lists the tags of remote which don't exist locally.
+func (r *LocalRepo) RemoteOnlyTags(remote string) ([]RemoteRef, error) {
+	refs, err := r.RemoteTags(remote)
+	if err != nil {
+		return nil, errors.Wrap(err, "RemoteTags")
+	}
+
+	return r.withoutLocal(refs, "refs/tags")
+}
+
+// IsTagPushed checks if the local tag exists on remote and points to the same object.
+func (r *LocalRepo) IsTagPushed(remote, tag string) (bool, error) {
+	local, err := r.output("rev-parse", "--verify", "refs/tags/"+tag)
+	if err != nil {
+		return false, errors.Wrap(err, "git [rev-parse]")
+	}
+
+	refs, err := r.RemoteTags(remote)
+	if err != nil {
+		return false, errors.Wrap(err, "RemoteTags")
+	}
+
+	for _, ref := range refs {
+		if ref.Name == tag {
+			return ref.Hash == local, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *LocalRepo) lsRemote(remote, kind, prefix string) ([]RemoteRef, error) {
+	out, err := r.output("ls-remote", "--refs", kind, remote)
+	if err != nil {
+		return nil, errors.Wrapf(err, "git [ls-remote %s]", kind)
+	}
+
+	refs := []RemoteRef{}
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid remote ref %q", line)
+		}
+
+		refs = append(refs, RemoteRef{
+			Name: strings.TrimPrefix(fields[1], prefix),
+			Hash: fields[0],
+		})
+	}
+
+	return refs, nil
+}
+
+func (r *LocalRepo) withoutLocal(refs []RemoteRef, namespace string) ([]RemoteRef, error) {
+	out, err := r.output("for-each-ref", "--format=%(refname)", namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "git [for-each-ref]")
+	}
+
+	local := map[string]bool{}
+	for _, name := range splitLines(out) {
+		local[strings.TrimPrefix(name, namespace+"/")] = true
+	}
+
+	remoteOnly := []RemoteRef{}
+	for _, ref := range refs {
+		if !local[ref.Name] {
+			remoteOnly = append(remoteOnly, ref)
+		}
+	}
+
+	return remoteOnly, nil
+}
@@ -0,0 +1,90 @@
+package git
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemotes(t *testing.T) {
+	origin := filepath.Join(t.TempDir(), "origin.git")
+	runGit(t, filepath.Dir(origin), "init", "-q", "--bare", "-b", "main", origin)
+
+	other := t.TempDir()
+	runGit(t, other, "clone", "-q", origin, ".")
+	runGit(t, other, "checkout", "-q", "-b", "main")
+	runGit(t, other, "commit", "-q", "--allow-empty", "-m", "initial commit")
+	runGit(t, other, "tag", "-a", "-m", "v1", "v1.0.0")
+	runGit(t, other, "push", "-q", "origin", "main", "v1.0.0")
+	runGit(t, other, "push", "-q", "origin", "main:feature")
+
+	dir := t.TempDir()
+	runGit(t, dir, "clone", "-q", origin, ".")
+	runGit(t, dir, "remote", "add", "mirror", "https://example.com/mirror.git")
+	runGit(t, dir, "remote", "set-url", "--add", "--push", "mirror", "https://example.com/push.git")
+	backup := filepath.Join(t.TempDir(), "My Repos", "backup.git")
+	runGit(t, dir, "remote", "add", "backup", backup)
+
+	repo := Repo(dir)
+
+	remotes, err := repo.Remotes()
+	require.NoError(t, err)
+	assert.Equal(t, []Remote{
+		{Name: "backup", FetchURLs: []string{backup}, PushURLs: []string{backup}},
+		{Name: "mirror", FetchURLs: []string{"https://example.com/mirror.git"}, PushURLs: []string{"https://example.com/push.git"}},
+		{Name: "origin", FetchURLs: []string{origin}, PushURLs: []string{origin}},
+	}, remotes)
+
+	upstream, err := repo.Upstream("")
+	require.NoError(t, err)
+	assert.Equal(t, "origin/main", upstream)
+
+	status, err := GitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "origin", status.Remote())
+	assert.Equal(t, "origin/main", status.Upstream())
+
+	// remote names may contain slashes
+	runGit(t, dir, "remote", "add", "team/upstream", origin)
+	runGit(t, dir, "fetch", "-q", "team/upstream")
+	runGit(t, dir, "checkout", "-q", "-b", "team", "--track", "team/upstream/feature")
+	status, err = GitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "team/upstream", status.Remote())
+	assert.Equal(t, "team/upstream/feature", status.Upstream())
+
+	// a local upstream has no remote
+	runGit(t, dir, "checkout", "-q", "-b", "develop", "--track", "main")
+	status, err = GitStatus(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "", status.Remote())
+	assert.Equal(t, "main", status.Upstream())
+
+	runGit(t, dir, "checkout", "-q", "-b", "local")
+	_, err = repo.Upstream("local")
+	assert.Equal(t, ErrNoUpstream, err)
+
+	branches, err := repo.RemoteOnlyBranches("origin")
+	require.NoError(t, err)
+	require.Len(t, branches, 1)
+	assert.Equal(t, "feature", branches[0].Name)
+
+	runGit(t, other, "tag", "v1.1.0")
+	runGit(t, other, "push", "-q", "origin", "v1.1.0")
+
+	tags, err := repo.RemoteOnlyTags("origin")
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	assert.Equal(t, "v1.1.0", tags[0].Name)
+
+	pushed, err := repo.IsTagPushed("origin", "v1.0.0")
+	require.NoError(t, err)
+	assert.True(t, pushed)
+
+	runGit(t, dir, "tag", "v2.0.0")
+	pushed, err = repo.IsTagPushed("origin", "v2.0.0")
+	require.NoError(t, err)
+	assert.False(t, pushed)
+}
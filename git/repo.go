@@ -0,0 +1,212 @@
+package git
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/pkg/errors"
+)
+
+// LocalRepo runs git commands in an explicit repository path instead of the process working directory.
+type LocalRepo struct {
+	path string
+}
+
+// Repo returns a handle for the repository at path. Environment variables in path are expanded.
+// An empty path refers to the process working directory.
+func Repo(path string) *LocalRepo {
+	return &LocalRepo{path: os.ExpandEnv(path)}
+}
+
+// Path returns the repository path of the handle.
+func (r *LocalRepo) Path() string {
+	return r.path
+}
+
+// CurrentCommit returns the full hash of HEAD.
+func (r *LocalRepo) CurrentCommit() (string, error) {
+	return r.Commit("HEAD")
+}
+
+// Commit resolves commitish to a full commit hash.
+func (r *LocalRepo) Commit(commitish string) (string, error) {
+	if commitish == "" {
+		return "", ErrCommitNotDefined
+	}
+
+	commit, err := r.output("rev-parse", "--verify", commitish+"^{commit}")
+	if err != nil {
+		return "", errors.Wrap(err, "git [rev-parse]")
+	}
+
+	return commit, nil
+}
+
+// ShortHash resolves commitish to an abbreviated commit hash.
+func (r *LocalRepo) ShortHash(commitish string) (string, error) {
+	if commitish == "" {
+		return "", ErrCommitNotDefined
+	}
+
+	commit, err := r.output("rev-parse", "--short", commitish+"^{commit}")
+	if err != nil {
+		return "", errors.Wrap(err, "git [rev-parse --short]")
+	}
+
+	return commit, nil
+}
+
+// Branch returns the name of the checked out branch, or HEAD if detached.
+func (r *LocalRepo) Branch() (string, error) {
+	branch, err := r.output("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", errors.Wrap(err, "git [rev-parse --abbrev-ref]")
+	}
+
+	return branch, nil
+}
+
+// Checkout checks out the given branch or commit.
+func (r *LocalRepo) Checkout(branch string) error {
+	if _, err := r.output("checkout", branch); err != nil {
+		return errors.Wrap(err, "git [checkout]")
+	}
+
+	return nil
+}
+
+// Tags returns all tags of the repository, newest first.
+func (r *LocalRepo) Tags() ([]string, error) {
+	output, err := r.output("tag", "--sort=-creatordate")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [tag]")
+	}
+
+	return splitLines(output), nil
+}
+
+// TagsAt returns the tags pointing at commitish, newest first.
+func (r *LocalRepo) TagsAt(commitish string) ([]string, error) {
+	if commitish == "" {
+		return nil, ErrCommitNotDefined
+	}
+
+	output, err := r.output("tag", "--points-at", commitish, "--sort=-creatordate")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [tag --points-at]")
+	}
+
+	return splitLines(output), nil
+}
+
+// TagsContaining returns the tags whose history contains commitish, newest first.
+func (r *LocalRepo) TagsContaining(commitish string) ([]string, error) {
+	if commitish == "" {
+		return nil, ErrCommitNotDefined
+	}
+
+	output, err := r.output("tag", "--contains", commitish, "--sort=-creatordate")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [tag --contains]")
+	}
+
+	return splitLines(output), nil
+}
+
+// IsCommitTagged reports whether any tag contains commitish.
+func (r *LocalRepo) IsCommitTagged(commitish string) (bool, error) {
+	tags, err := r.TagsContaining(commitish)
+	if err != nil {
+		return false, errors.Wrap(err, "TagsContaining")
+	}
+
+	return len(tags) > 0, nil
+}
+
+// MostRecentTag returns the most recent tag reachable from commitish.
+func (r *LocalRepo) MostRecentTag(commitish string) (string, error) {
+	if commitish == "" {
+		return "", ErrCommitNotDefined
+	}
+
+	tag, err := r.output("describe", "--tags", "--abbrev=0", commitish)
+	if err != nil {
+		return "", errors.Wrap(err, "git [describe --tags]")
+	}
+
+	return tag, nil
+}
+
+// Describe returns `git describe --tags --always` output for commitish, e.g. `v1.2.0-3-gdeadbee`.
+// An empty commitish describes the worktree and appends `-dirty` if it has local modifications.
+func (r *LocalRepo) Describe(commitish string) (string, error) {
+	args := []string{"describe", "--tags", "--always"}
+	if commitish == "" {
+		args = append(args, "--dirty")
+	} else {
+		args = append(args, commitish)
+	}
+
+	desc, err := r.output(args...)
+	if err != nil {
+		return "", errors.Wrap(err, "git [describe]")
+	}
+
+	return desc, nil
+}
+
+// CommitTime returns the committer timestamp of commitish, which is stable across rebuilds of the same commit.
+func (r *LocalRepo) CommitTime(commitish string) (time.Time, error) {
+	if commitish == "" {
+		return time.Time{}, ErrCommitNotDefined
+	}
+
+	output, err := r.output("log", "-1", "--format=%ct", commitish)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "git [log -1]")
+	}
+
+	seconds, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "ParseInt")
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// EnsureBranch checks out branchName if it isn't the current branch.
+func (r *LocalRepo) EnsureBranch(branchName string) error {
+	branch, err := r.Branch()
+	if err != nil {
+		return errors.Wrap(err, "Branch")
+	}
+
+	if branch != branchName {
+		logging.Infof("checkout [%s] in repository [%s]", branchName, r.path)
+		return r.Checkout(branchName)
+	}
+
+	logging.Infof("branch [%s] is checked out in repository [%s]", branchName, r.path)
+	return nil
+}
+
+func (r *LocalRepo) output(args ...string) (string, error) {
+	return gitOutputIn(context.Background(), r.path, args...)
+}
+
+// rawOutput returns the untrimmed output of git commands with `-z`, see splitNUL.
+func (r *LocalRepo) rawOutput(args ...string) (string, error) {
+	return gitRawOutputIn(context.Background(), r.path, args...)
+}
+
+func splitLines(output string) []string {
+	if output == "" {
+		return nil
+	}
+
+	return strings.Split(output, "\n")
+}
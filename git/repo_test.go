@@ -0,0 +1,86 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalRepo(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	t.Setenv("GIT_COMMITTER_DATE", "@1600000000 +0000")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "initial commit")
+	runGit(t, dir, "tag", "v1.0.0")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "second commit")
+
+	repo := Repo(dir)
+
+	head, err := repo.CurrentCommit()
+	require.NoError(t, err)
+	assert.Len(t, head, 40)
+
+	first, err := repo.Commit("HEAD~1")
+	require.NoError(t, err)
+	assert.NotEqual(t, head, first)
+
+	short, err := repo.ShortHash("HEAD")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(head, short))
+
+	branch, err := repo.Branch()
+	require.NoError(t, err)
+	assert.Equal(t, "main", branch)
+
+	tags, err := repo.TagsAt(first)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v1.0.0"}, tags)
+
+	tags, err = repo.TagsAt(head)
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	tags, err = repo.Tags()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v1.0.0"}, tags)
+
+	tagged, err := repo.IsCommitTagged(head)
+	require.NoError(t, err)
+	assert.False(t, tagged)
+
+	tagged, err = repo.IsCommitTagged(first)
+	require.NoError(t, err)
+	assert.True(t, tagged)
+
+	tag, err := repo.MostRecentTag("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0", tag)
+
+	desc, err := repo.Describe("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0-1-g"+short, desc)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644))
+	runGit(t, dir, "add", "file")
+	desc, err = repo.Describe("")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0-1-g"+short+"-dirty", desc)
+
+	ts, err := repo.CommitTime("HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1600000000, 0).UTC(), ts)
+
+	_, err = repo.TagsContaining("")
+	assert.Equal(t, ErrCommitNotDefined, err)
+
+	runGit(t, dir, "branch", "release")
+	require.NoError(t, repo.EnsureBranch("release"))
+	branch, err = repo.Branch()
+	require.NoError(t, err)
+	assert.Equal(t, "release", branch)
+}
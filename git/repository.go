@@ -3,23 +3,45 @@ package git
 import (
 	"io"
 	"path/filepath"
-	"time"
 
 	"github.com/denkhaus/logging"
 	"github.com/pkg/errors"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 )
 
 type GitRepository struct {
 	path    string
 	repoURL string
+	repo    *git.Repository
+	auth    AuthProvider
 }
 
+// RepositoryOption configures a GitRepository.
+type RepositoryOption func(*GitRepository)
+
+// WithAuth sets the auth provider used by clone, fetch, pull and push.
+func WithAuth(provider AuthProvider) RepositoryOption {
+	return func(p *GitRepository) {
+		p.auth = provider
+	}
+}
+
+// Name is the fallback author name if user.name is not configured.
+//
+// Deprecated: configure user.name or use WithAuthor.
 var Name = "Repo Maintainer"
+
+// Email is the fallback author email if user.email is not configured.
+//
+// Deprecated: configure user.email or use WithAuthor.
 var Email = "unknown@github"
 
-func NewGitRepository(repoPath, repoURL string) (*GitRepository, error) {
+func NewGitRepository(repoPath, repoURL string, opts ...RepositoryOption) (*GitRepository, error) {
 	path, err := filepath.Abs(repoPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "Abs")
@@ -30,12 +52,38 @@ func NewGitRepository(repoPath, repoURL string) (*GitRepository, error) {
 		repoURL: repoURL,
 	}
 
+	for _, opt := range opts {
+		opt(&rep)
+	}
+
 	return &rep, nil
 }
 
+// Path returns the absolute path of the repository.
+func (p *GitRepository) Path() string {
+	return p.path
+}
+
+// Open opens an already existing repository at the repository path.
+func (p *GitRepository) Open() error {
+	r, err := git.PlainOpen(p.path)
+	if err != nil {
+		return errors.Wrap(err, "PlainOpen")
+	}
+
+	p.repo = r
+	return nil
+}
+
 func (p *GitRepository) Clone(w io.Writer) error {
-	_, err := git.PlainClone(p.path, false, &git.CloneOptions{
+	auth, err := p.authMethod()
+	if err != nil {
+		return errors.Wrap(err, "authMethod")
+	}
+
+	r, err := git.PlainClone(p.path, false, &git.CloneOptions{
 		URL:      p.repoURL,
+		Auth:     auth,
 		Progress: w,
 	})
 
@@ -43,49 +91,305 @@ func (p *GitRepository) Clone(w io.Writer) error {
 		return errors.Wrap(err, "PlainClone")
 	}
 
+	p.repo = r
 	return nil
 }
 
-func (p *GitRepository) CommitAll(message string) error {
-	r, err := git.PlainOpen(p.path)
+// Fetch fetches the given refspecs from the origin remote.
+// If no refspecs are provided, the refspecs of the remote config are used.
+func (p *GitRepository) Fetch(w io.Writer, refSpecs ...string) error {
+	r, err := p.repository()
 	if err != nil {
-		return errors.Wrap(err, "PlainOpen")
+		return errors.Wrap(err, "repository")
+	}
+
+	specs, err := parseRefSpecs(refSpecs)
+	if err != nil {
+		return errors.Wrap(err, "parseRefSpecs")
+	}
+
+	auth, err := p.authMethod()
+	if err != nil {
+		return errors.Wrap(err, "authMethod")
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   specs,
+		Auth:       auth,
+		Progress:   w,
+		Tags:       git.AllTags,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrap(err, "Fetch")
 	}
 
-	w, err := r.Worktree()
+	return nil
+}
+
+// Pull fetches the checked out branch from the origin remote and merges it into the worktree.
+func (p *GitRepository) Pull(w io.Writer) error {
+	r, err := p.repository()
+	if err != nil {
+		return errors.Wrap(err, "repository")
+	}
+
+	wt, err := r.Worktree()
 	if err != nil {
 		return errors.Wrap(err, "Worktree")
 	}
 
-	status, err := w.Status()
+	head, err := r.Head()
+	if err != nil {
+		return errors.Wrap(err, "Head")
+	}
+
+	auth, err := p.authMethod()
+	if err != nil {
+		return errors.Wrap(err, "authMethod")
+	}
+
+	err = wt.Pull(&git.PullOptions{
+		RemoteName:    git.DefaultRemoteName,
+		ReferenceName: head.Name(),
+		SingleBranch:  true,
+		Auth:          auth,
+		Progress:      w,
+	})
+
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrap(err, "Pull")
+	}
+
+	return nil
+}
+
+// Push pushes the given refspecs to the origin remote.
+// If no refspecs are provided, all local branches are pushed.
+func (p *GitRepository) Push(w io.Writer, refSpecs ...string) error {
+	r, err := p.repository()
 	if err != nil {
-		return errors.Wrap(err, "Status")
+		return errors.Wrap(err, "repository")
 	}
 
-	logging.Info(status)
+	specs, err := parseRefSpecs(refSpecs)
+	if err != nil {
+		return errors.Wrap(err, "parseRefSpecs")
+	}
 
-	if err := w.AddGlob("./**/*"); err != nil {
-		return errors.Wrap(err, "AddGlob")
+	auth, err := p.authMethod()
+	if err != nil {
+		return errors.Wrap(err, "authMethod")
 	}
 
-	commit, err := w.Commit(message, &git.CommitOptions{
-		All: true,
-		Author: &object.Signature{
-			Name:  Name,
-			Email: Email,
-			When:  time.Now(),
-		},
+	err = r.Push(&git.PushOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   specs,
+		Auth:       auth,
+		Progress:   w,
 	})
 
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrap(err, "Push")
+	}
+
+	return nil
+}
+
+// CreateBranch creates a local branch pointing to revision.
+// An empty revision creates the branch at HEAD.
+func (p *GitRepository) CreateBranch(name, revision string) error {
+	r, err := p.repository()
 	if err != nil {
-		return errors.Wrap(err, "Commit")
+		return errors.Wrap(err, "repository")
 	}
 
-	obj, err := r.CommitObject(commit)
+	hash, err := resolveRevision(r, revision)
 	if err != nil {
-		return errors.Wrap(err, "CommitObject")
+		return errors.Wrap(err, "resolveRevision")
+	}
+
+	refName := plumbing.NewBranchReferenceName(name)
+	if _, err := r.Reference(refName, false); err == nil {
+		return errors.Errorf("branch %q already exists", name)
+	}
+
+	ref := plumbing.NewHashReference(refName, hash)
+	if err := r.Storer.SetReference(ref); err != nil {
+		return errors.Wrap(err, "SetReference")
 	}
 
-	logging.Info(obj)
 	return nil
 }
+
+// Checkout checks out the given local branch.
+func (p *GitRepository) Checkout(branch string) error {
+	r, err := p.repository()
+	if err != nil {
+		return errors.Wrap(err, "repository")
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return errors.Wrap(err, "Worktree")
+	}
+
+	err = wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Checkout")
+	}
+
+	return nil
+}
+
+// CreateTag creates a tag pointing to revision. An empty revision tags HEAD.
+// If message is not empty an annotated tag is created, otherwise a lightweight one.
+func (p *GitRepository) CreateTag(name, revision, message string) error {
+	r, err := p.repository()
+	if err != nil {
+		return errors.Wrap(err, "repository")
+	}
+
+	hash, err := resolveRevision(r, revision)
+	if err != nil {
+		return errors.Wrap(err, "resolveRevision")
+	}
+
+	var opts *git.CreateTagOptions
+	if message != "" {
+		tagger, err := p.identity(nil)
+		if err != nil {
+			return errors.Wrap(err, "identity")
+		}
+
+		opts = &git.CreateTagOptions{
+			Message: message,
+			Tagger:  tagger.signature(),
+		}
+	}
+
+	if _, err := r.CreateTag(name, hash, opts); err != nil {
+		return errors.Wrap(err, "CreateTag")
+	}
+
+	logging.Infof("created tag [%s] at [%s] in repository [%s]", name, hash, p.path)
+	return nil
+}
+
+// DeleteTag deletes the local tag with the given name.
+func (p *GitRepository) DeleteTag(name string) error {
+	r, err := p.repository()
+	if err != nil {
+		return errors.Wrap(err, "repository")
+	}
+
+	if err := r.DeleteTag(name); err != nil {
+		return errors.Wrap(err, "DeleteTag")
+	}
+
+	return nil
+}
+
+// Log returns up to limit commits reachable from revision, newest first.
+// An empty revision starts at HEAD, a limit <= 0 returns the whole history.
+func (p *GitRepository) Log(revision string, limit int) ([]*object.Commit, error) {
+	r, err := p.repository()
+	if err != nil {
+		return nil, errors.Wrap(err, "repository")
+	}
+
+	hash, err := resolveRevision(r, revision)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolveRevision")
+	}
+
+	iter, err := r.Log(&git.LogOptions{
+		From:  hash,
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Log")
+	}
+	defer iter.Close()
+
+	commits := []*object.Commit{}
+	err = iter.ForEach(func(c *object.Commit) error {
+		if limit > 0 && len(commits) >= limit {
+			return storer.ErrStop
+		}
+
+		commits = append(commits, c)
+		return nil
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "ForEach")
+	}
+
+	return commits, nil
+}
+
+// repository returns the opened repository, opening it on first use.
+func (p *GitRepository) repository() (*git.Repository, error) {
+	if p.repo == nil {
+		if err := p.Open(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.repo, nil
+}
+
+// authMethod resolves the auth method for the remote URL of the repository.
+// It returns nil if no auth provider is configured.
+func (p *GitRepository) authMethod() (transport.AuthMethod, error) {
+	if p.auth == nil {
+		return nil, nil
+	}
+
+	repoURL := p.repoURL
+	if repoURL == "" && p.repo != nil {
+		remote, err := p.repo.Remote(git.DefaultRemoteName)
+		if err != nil {
+			return nil, errors.Wrap(err, "Remote")
+		}
+
+		if urls := remote.Config().URLs; len(urls) > 0 {
+			repoURL = urls[0]
+		}
+	}
+
+	return p.auth(repoURL)
+}
+
+// resolveRevision resolves revision to a commit hash. An empty revision resolves to HEAD.
+func resolveRevision(r *git.Repository, revision string) (plumbing.Hash, error) {
+	if revision == "" {
+		revision = "HEAD"
+	}
+
+	hash, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return plumbing.ZeroHash, errors.Wrapf(err, "ResolveRevision [%s]", revision)
+	}
+
+	return *hash, nil
+}
+
+func parseRefSpecs(refSpecs []string) ([]config.RefSpec, error) {
+	specs := make([]config.RefSpec, 0, len(refSpecs))
+	for _, s := range refSpecs {
+		spec := config.RefSpec(s)
+		if err := spec.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid refspec %q", s)
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
@@ -0,0 +1,144 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+type repositoryTest struct {
+	suite.Suite
+	tempDir   string
+	originURL string
+}
+
+func (suite *repositoryTest) SetupTest() {
+	dir, err := ioutil.TempDir("", "magelib-git")
+	suite.Require().NoError(err)
+	suite.tempDir = dir
+
+	seedDir := filepath.Join(dir, "seed")
+	seed, err := git.PlainInit(seedDir, false)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(seedDir, "README.md"), []byte("seed\n"), 0644))
+
+	w, err := seed.Worktree()
+	suite.Require().NoError(err)
+
+	_, err = w.Add("README.md")
+	suite.Require().NoError(err)
+
+	_, err = w.Commit("initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "seed", Email: "seed@example.com", When: time.Now()},
+	})
+	suite.Require().NoError(err)
+
+	originDir := filepath.Join(dir, "origin.git")
+	_, err = git.PlainClone(originDir, true, &git.CloneOptions{URL: seedDir})
+	suite.Require().NoError(err)
+
+	suite.originURL = "file://" + originDir
+}
+
+func (suite *repositoryTest) TearDownTest() {
+	os.RemoveAll(suite.tempDir)
+}
+
+func (suite *repositoryTest) cloneRepository(name string) *GitRepository {
+	rep, err := NewGitRepository(filepath.Join(suite.tempDir, name), suite.originURL)
+	suite.Require().NoError(err)
+	suite.Require().NoError(rep.Clone(nil))
+	return rep
+}
+
+func (suite *repositoryTest) commitFile(rep *GitRepository, name, message string) {
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(rep.Path(), name), []byte(message+"\n"), 0644))
+
+	r, err := git.PlainOpen(rep.Path())
+	suite.Require().NoError(err)
+
+	w, err := r.Worktree()
+	suite.Require().NoError(err)
+
+	_, err = w.Add(name)
+	suite.Require().NoError(err)
+
+	_, err = w.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *repositoryTest) TestBranchTagPushFetch() {
+	rep := suite.cloneRepository("work")
+
+	suite.Require().NoError(rep.CreateBranch("release", ""))
+	suite.Require().Error(rep.CreateBranch("release", ""))
+	suite.Require().NoError(rep.Checkout("release"))
+
+	suite.commitFile(rep, "CHANGES.md", "add changes")
+
+	suite.Require().NoError(rep.CreateTag("v1.0.0", "", "release v1.0.0"))
+	suite.Require().NoError(rep.CreateTag("latest", "HEAD~1", ""))
+
+	suite.Require().NoError(rep.Push(nil,
+		"refs/heads/release:refs/heads/release",
+		"refs/tags/v1.0.0:refs/tags/v1.0.0",
+	))
+
+	commits, err := rep.Log("", 0)
+	suite.Require().NoError(err)
+	suite.Len(commits, 2)
+	suite.Equal("add changes", commits[0].Message)
+
+	commits, err = rep.Log("latest", 1)
+	suite.Require().NoError(err)
+	suite.Len(commits, 1)
+	suite.Equal("initial commit", commits[0].Message)
+
+	suite.Require().NoError(rep.DeleteTag("latest"))
+	suite.Error(rep.DeleteTag("latest"))
+
+	other := suite.cloneRepository("other")
+	suite.Require().NoError(other.Fetch(nil, "+refs/heads/*:refs/remotes/origin/*"))
+
+	r, err := git.PlainOpen(other.Path())
+	suite.Require().NoError(err)
+
+	tag, err := r.Tag("v1.0.0")
+	suite.Require().NoError(err)
+
+	annotated, err := r.TagObject(tag.Hash())
+	suite.Require().NoError(err)
+	suite.Equal("release v1.0.0\n", annotated.Message)
+
+	_, err = r.Reference(plumbing.NewRemoteReferenceName("origin", "release"), true)
+	suite.NoError(err)
+}
+
+func (suite *repositoryTest) TestPull() {
+	rep := suite.cloneRepository("work")
+	other := suite.cloneRepository("other")
+
+	suite.commitFile(rep, "CHANGES.md", "add changes")
+	suite.Require().NoError(rep.Push(nil))
+
+	suite.Require().NoError(other.Open())
+	suite.Require().NoError(other.Pull(nil))
+	suite.FileExists(filepath.Join(other.Path(), "CHANGES.md"))
+
+	suite.NoError(other.Pull(nil))
+}
+
+func TestRepository(t *testing.T) {
+	testSuite := new(repositoryTest)
+	suite.Run(t, testSuite)
+}
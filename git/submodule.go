@@ -0,0 +1,187 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/pkg/errors"
+)
+
+// Submodule describes a submodule of a repository.
+type Submodule struct {
+	// Path is the submodule path relative to the top level repository.
+	Path string
+	// Recorded is the commit recorded in the index of the superproject.
+	Recorded string
+	// CheckedOut is the commit checked out in the submodule, empty if not initialized.
+	CheckedOut string
+	// Initialized is false until the submodule has been cloned by `git submodule update --init`.
+	Initialized bool
+	// Conflict is true if the submodule has merge conflicts.
+	Conflict bool
+}
+
+// IsModified returns true if the checked out commit differs from the recorded one.
+func (s Submodule) IsModified() bool {
+	return s.Initialized && s.CheckedOut != s.Recorded
+}
+
+// Submodules lists the submodules of the repository recursively with their recorded and checked out commits.
+func (r *LocalRepo) Submodules() ([]Submodule, error) {
+	out, err := r.output("submodule", "status", "--recursive")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [submodule status]")
+	}
+
+	cached, err := r.output("submodule", "status", "--cached", "--recursive")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [submodule status --cached]")
+	}
+
+	recorded := map[string]string{}
+	for _, line := range splitLines(cached) {
+		_, commit, path, err := parseSubmoduleStatus(line)
+		if err != nil {
+			return nil, errors.Wrap(err, "parseSubmoduleStatus")
+		}
+		recorded[path] = commit
+	}
+
+	submodules := []Submodule{}
+	for _, line := range splitLines(out) {
+		state, commit, path, err := parseSubmoduleStatus(line)
+		if err != nil {
+			return nil, errors.Wrap(err, "parseSubmoduleStatus")
+		}
+
+		sub := Submodule{
+			Path:        path,
+			Recorded:    recorded[path],
+			Initialized: state != '-',
+			Conflict:    state == 'U',
+		}
+
+		if sub.Initialized {
+			sub.CheckedOut = commit
+		}
+
+		submodules = append(submodules, sub)
+	}
+
+	return submodules, nil
+}
+
+// SyncSubmodulesCmd as magelib.Cmd
+func SyncSubmodulesCmd(path string) magelib.Cmd {
+	return func() error {
+		return Repo(path).SyncSubmodules()
+	}
+}
+
+// SyncSubmodules updates the remote URLs of all submodules recursively from .gitmodules.
+func (r *LocalRepo) SyncSubmodules() error {
+	if _, err := r.output("submodule", "sync", "--recursive"); err != nil {
+		return errors.Wrap(err, "git [submodule sync]")
+	}
+
+	return nil
+}
+
+// UpdateSubmodulesCmd as magelib.Cmd
+func UpdateSubmodulesCmd(path string) magelib.Cmd {
+	return func() error {
+		return Repo(path).UpdateSubmodules()
+	}
+}
+
+// UpdateSubmodules initializes all submodules recursively and checks out their recorded commits.
+func (r *LocalRepo) UpdateSubmodules() error {
+	logging.Infof("update submodules in repository [%s]", r.path)
+	if _, err := r.output("submodule", "update", "--init", "--recursive"); err != nil {
+		return errors.Wrap(err, "git [submodule update]")
+	}
+
+	return nil
+}
+
+// BumpSubmoduleCmd as magelib.Cmd
+func BumpSubmoduleCmd(path, submodule, branch string) magelib.Cmd {
+	return func() error {
+		_, err := Repo(path).BumpSubmodule(submodule, branch)
+		return err
+	}
+}
+
+// BumpSubmodule checks out the tip of branch from the origin of the submodule at path
+// and commits the new submodule pointer. Other staged changes are not committed.
+//
+// Parameters:
+// - path: the submodule path relative to the repository.
+// - branch: the branch of the submodule remote to bump to.
+//
+// Returns:
+// - string: the hash of the created commit.
+// - error: ErrNothingToCommit if the submodule is already at the tip of branch.
+func (r *LocalRepo) BumpSubmodule(path, branch string) (string, error) {
+	ctx := context.Background()
+	dir := filepath.Join(r.path, path)
+
+	if _, err := gitOutputIn(ctx, dir, "fetch", "origin", branch); err != nil {
+		return "", errors.Wrap(err, "git [fetch]")
+	}
+
+	if _, err := gitOutputIn(ctx, dir, "checkout", "--detach", "FETCH_HEAD"); err != nil {
+		return "", errors.Wrap(err, "git [checkout]")
+	}
+
+	short, err := gitOutputIn(ctx, dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", errors.Wrap(err, "git [rev-parse --short]")
+	}
+
+	if _, err := r.output("add", "--", path); err != nil {
+		return "", errors.Wrap(err, "git [add]")
+	}
+
+	if _, err := r.output("diff", "--cached", "--quiet", "--", path); err == nil {
+		return "", ErrNothingToCommit
+	}
+
+	message := fmt.Sprintf("chore(deps): bump %s to %s", path, short)
+	if _, err := r.output("commit", "-m", message, "--", path); err != nil {
+		return "", errors.Wrap(err, "git [commit]")
+	}
+
+	logging.Infof("bumped submodule [%s] to [%s] of branch [%s]", path, short, branch)
+	return r.CurrentCommit()
+}
+
+// submoduleDescribe matches the ` (<describe>)` suffix of a submodule status line.
+// The output of git describe contains neither spaces nor parentheses.
+var submoduleDescribe = regexp.MustCompile(` \([^ ()]*\)$`)
+
+// parseSubmoduleStatus parses a line of `git submodule status`, `[ +-U]<commit> <path>[ (<describe>)]`.
+// The leading space of unchanged submodules may be trimmed, the path may contain spaces.
+func parseSubmoduleStatus(line string) (byte, string, string, error) {
+	state := byte(' ')
+	if line != "" && strings.IndexByte("+-U ", line[0]) >= 0 {
+		state, line = line[0], line[1:]
+	}
+
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) < 2 || fields[0] == "" {
+		return 0, "", "", errors.Errorf("invalid submodule status %q", line)
+	}
+
+	path := submoduleDescribe.ReplaceAllString(fields[1], "")
+	if path == "" {
+		return 0, "", "", errors.Errorf("invalid submodule status %q", line)
+	}
+
+	return state, fields[0], path, nil
+}
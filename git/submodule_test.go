@@ -0,0 +1,134 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// submoduleEnv allows cloning submodules over the file transport and sets a fixed identity.
+func submoduleEnv(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+}
+
+func TestSubmodules(t *testing.T) {
+	submoduleEnv(t)
+
+	lib := t.TempDir()
+	runGit(t, lib, "init", "-q", "-b", "main")
+	runGit(t, lib, "commit", "-q", "--allow-empty", "-m", "initial commit")
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "submodule", "add", "-q", lib, "lib")
+	runGit(t, dir, "commit", "-q", "-m", "add lib")
+
+	repo := Repo(dir)
+	require.NoError(t, IsRepoClean(dir))
+
+	recorded, err := Repo(lib).CurrentCommit()
+	require.NoError(t, err)
+
+	subs, err := repo.Submodules()
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	assert.Equal(t, Submodule{Path: "lib", Recorded: recorded, CheckedOut: recorded, Initialized: true}, subs[0])
+
+	// a new commit on the submodule branch is picked up by BumpSubmodule
+	runGit(t, lib, "commit", "-q", "--allow-empty", "-m", "second commit")
+	tip, err := Repo(lib).CurrentCommit()
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "staged"), []byte("staged"), 0644))
+	runGit(t, dir, "add", "staged")
+
+	_, err = repo.BumpSubmodule("lib", "main")
+	require.NoError(t, err)
+
+	subs, err = repo.Submodules()
+	require.NoError(t, err)
+	assert.Equal(t, tip, subs[0].Recorded)
+	assert.False(t, subs[0].IsModified())
+
+	// only the submodule pointer is committed
+	status, err := GitStatus(dir)
+	require.NoError(t, err)
+	assert.True(t, status.IsDirty())
+
+	_, err = repo.BumpSubmodule("lib", "main")
+	assert.Equal(t, ErrNothingToCommit, err)
+	runGit(t, dir, "commit", "-q", "-m", "add staged")
+
+	// untracked files in the submodule make the repository unclean
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "lib", "untracked"), []byte("untracked"), 0644))
+	assert.Error(t, IsRepoClean(dir))
+
+	// a checkout differing from the recorded commit is reported as modified
+	runGit(t, filepath.Join(dir, "lib"), "checkout", "-q", "HEAD~1")
+	subs, err = repo.Submodules()
+	require.NoError(t, err)
+	assert.True(t, subs[0].IsModified())
+
+	require.NoError(t, repo.SyncSubmodules())
+	require.NoError(t, repo.UpdateSubmodules())
+
+	subs, err = repo.Submodules()
+	require.NoError(t, err)
+	assert.False(t, subs[0].IsModified())
+}
+
+func TestSubmodulePathWithSpaces(t *testing.T) {
+	submoduleEnv(t)
+
+	lib := t.TempDir()
+	runGit(t, lib, "init", "-q", "-b", "main")
+	runGit(t, lib, "commit", "-q", "--allow-empty", "-m", "initial commit")
+	runGit(t, lib, "tag", "v1.0.0")
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "submodule", "add", "-q", lib, "third party/my lib")
+	runGit(t, dir, "commit", "-q", "-m", "add lib")
+
+	recorded, err := Repo(lib).CurrentCommit()
+	require.NoError(t, err)
+
+	subs, err := Repo(dir).Submodules()
+	require.NoError(t, err)
+	assert.Equal(t, []Submodule{{Path: "third party/my lib", Recorded: recorded, CheckedOut: recorded, Initialized: true}}, subs)
+}
+
+func TestParseSubmoduleStatus(t *testing.T) {
+	tests := []struct {
+		line   string
+		state  byte
+		commit string
+		path   string
+	}{
+		{" abc lib (v1.0.0)", ' ', "abc", "lib"},
+		{"abc lib", ' ', "abc", "lib"},
+		{"+abc my lib (heads/main)", '+', "abc", "my lib"},
+		{"-abc my lib", '-', "abc", "my lib"},
+		{"Uabc lib (old) (v1.0.0-2-gabc)", 'U', "abc", "lib (old)"},
+	}
+
+	for _, test := range tests {
+		state, commit, path, err := parseSubmoduleStatus(test.line)
+		require.NoError(t, err, test.line)
+		assert.Equal(t, test.state, state, test.line)
+		assert.Equal(t, test.commit, commit, test.line)
+		assert.Equal(t, test.path, path, test.line)
+	}
+
+	_, _, _, err := parseSubmoduleStatus("abc")
+	assert.Error(t, err)
+}
@@ -1,37 +1,328 @@
 package git
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
 
-	"github.com/denkhaus/magelib"
-	"github.com/usvc/go-semver"
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
 )
 
-// NextVersion generates the next version based on the most recent tag and the maximum patch count.
+var (
+	ErrNoChanges = errors.New("no releasable commits since last release")
+)
+
+var conventionalHeader = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+
+// Bump is the semantic version increment implied by a set of commits.
+type Bump int
+
+const (
+	BumpNone Bump = iota
+	BumpPatch
+	BumpMinor
+	BumpMajor
+)
+
+func (b Bump) String() string {
+	switch b {
+	case BumpPatch:
+		return "patch"
+	case BumpMinor:
+		return "minor"
+	case BumpMajor:
+		return "major"
+	}
+	return "none"
+}
+
+// ConventionalCommit is a commit message following https://www.conventionalcommits.org.
+type ConventionalCommit struct {
+	Hash     string
+	Type     string
+	Scope    string
+	Subject  string
+	Body     string
+	Breaking bool
+}
+
+// ParseConventionalCommit parses a commit message. It returns false if the header
+// doesn't follow the `type(scope)!: subject` convention.
+func ParseConventionalCommit(hash, message string) (*ConventionalCommit, bool) {
+	lines := strings.SplitN(strings.TrimSpace(message), "\n", 2)
+	match := conventionalHeader.FindStringSubmatch(strings.TrimSpace(lines[0]))
+	if match == nil {
+		return nil, false
+	}
+
+	commit := ConventionalCommit{
+		Hash:     hash,
+		Type:     strings.ToLower(match[1]),
+		Scope:    match[2],
+		Subject:  match[4],
+		Breaking: match[3] == "!",
+	}
+
+	if len(lines) > 1 {
+		commit.Body = strings.TrimSpace(lines[1])
+		for _, line := range strings.Split(commit.Body, "\n") {
+			if strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:") {
+				commit.Breaking = true
+			}
+		}
+	}
+
+	return &commit, true
+}
+
+// Bump returns the version increment the commit requires.
+func (c *ConventionalCommit) Bump() Bump {
+	switch {
+	case c.Breaking:
+		return BumpMajor
+	case c.Type == "feat":
+		return BumpMinor
+	case c.Type == "fix" || c.Type == "perf":
+		return BumpPatch
+	}
+	return BumpNone
+}
+
+// VersionInfo is the result of a version calculation.
+type VersionInfo struct {
+	// Previous is the most recent release tag, empty if there is none.
+	Previous string
+	// Next is the calculated version including the tag prefix of Previous.
+	Next string
+	Bump Bump
+	// Commits are the conventional commits since Previous, newest first.
+	Commits []*ConventionalCommit
+}
+
+type versionConfig struct {
+	channel  string
+	metadata string
+}
+
+// VersionOption configures the version calculation.
+type VersionOption func(*versionConfig)
+
+// WithChannel calculates a pre-release version like `1.2.0-rc.3` for the given channel.
+// The counter continues from the highest existing tag of the channel.
+func WithChannel(channel string) VersionOption {
+	return func(c *versionConfig) {
+		c.channel = channel
+	}
+}
+
+// WithBuildMetadata appends build metadata like `+build.42` to the version.
+func WithBuildMetadata(metadata string) VersionOption {
+	return func(c *versionConfig) {
+		c.metadata = metadata
+	}
+}
+
+// NextVersion calculates the next version based on the conventional commits since the most recent release tag.
 //
-// The ctx parameter is used to handle the context of the function call.
-// The maxCount parameter specifies the maximum patch count before bumping the minor version.
+// The ctx parameter is used to cancel the underlying git commands.
 // Returns the next version as a string and an error if any occurs.
-func NextVersion(ctx context.Context, maxCount uint) (string, error) {
-	tag, err := MostRecentTag()
+func NextVersion(ctx context.Context, opts ...VersionOption) (string, error) {
+	info, err := CalculateVersion(ctx, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Next, nil
+}
+
+// CalculateVersion reads the commits since the most recent release tag reachable from HEAD,
+// classifies them as conventional commits and bumps the version by the highest ConventionalCommit.Bump.
+// It returns ErrNoChanges if no commit requires a bump, e.g. only `chore` or non-conventional commits.
+// Without a release tag the calculation starts at 0.0.0.
+func CalculateVersion(ctx context.Context, opts ...VersionOption) (*VersionInfo, error) {
+	cfg := versionConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	previous, version, err := lastReleaseTag(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "lastReleaseTag")
+	}
+
+	revRange := "HEAD"
+	if previous != "" {
+		revRange = previous + "..HEAD"
+	}
+
+	messages, err := commitMessages(ctx, revRange)
+	if err != nil {
+		return nil, errors.Wrap(err, "commitMessages")
+	}
+
+	if len(messages) == 0 {
+		return nil, ErrNoChanges
+	}
+
+	info := VersionInfo{
+		Previous: previous,
+		Bump:     BumpNone,
+	}
+
+	for _, m := range messages {
+		commit, ok := ParseConventionalCommit(m[0], m[1])
+		if !ok {
+			continue
+		}
+
+		info.Commits = append(info.Commits, commit)
+		if bump := commit.Bump(); bump > info.Bump {
+			info.Bump = bump
+		}
+	}
+
+	var next semver.Version
+	switch info.Bump {
+	case BumpMajor:
+		next = version.IncMajor()
+	case BumpMinor:
+		next = version.IncMinor()
+	case BumpPatch:
+		next = version.IncPatch()
+	default:
+		return nil, ErrNoChanges
+	}
+
+	if cfg.channel != "" {
+		n, err := nextPrereleaseNumber(ctx, next, cfg.channel)
+		if err != nil {
+			return nil, errors.Wrap(err, "nextPrereleaseNumber")
+		}
+
+		next, err = next.SetPrerelease(fmt.Sprintf("%s.%d", cfg.channel, n))
+		if err != nil {
+			return nil, errors.Wrap(err, "SetPrerelease")
+		}
+	}
+
+	if cfg.metadata != "" {
+		next, err = next.SetMetadata(cfg.metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "SetMetadata")
+		}
+	}
+
+	info.Next = tagPrefix(previous) + next.String()
+	return &info, nil
+}
+
+// lastReleaseTag returns the highest tag reachable from HEAD that is a semantic version without pre-release.
+func lastReleaseTag(ctx context.Context) (string, *semver.Version, error) {
+	output, err := gitOutput(ctx, "tag", "--merged", "HEAD")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "git [tag --merged]")
+	}
+
+	var tag string
+	version := semver.New(0, 0, 0, "", "")
+	for _, t := range strings.Fields(output) {
+		v, err := semver.NewVersion(t)
+		if err != nil || v.Prerelease() != "" {
+			continue
+		}
+
+		if tag == "" || v.GreaterThan(version) {
+			tag, version = t, v
+		}
+	}
+
+	return tag, version, nil
+}
+
+// nextPrereleaseNumber returns the number following the highest existing `<version>-<channel>.N` tag.
+func nextPrereleaseNumber(ctx context.Context, version semver.Version, channel string) (int, error) {
+	output, err := gitOutput(ctx, "tag", "--list")
+	if err != nil {
+		return 0, errors.Wrap(err, "git [tag --list]")
+	}
+
+	prefix := channel + "."
+	next := 1
+	for _, t := range strings.Fields(output) {
+		v, err := semver.NewVersion(t)
+		if err != nil || !strings.HasPrefix(v.Prerelease(), prefix) {
+			continue
+		}
+
+		if v.Major() != version.Major() || v.Minor() != version.Minor() || v.Patch() != version.Patch() {
+			continue
+		}
+
+		if n, err := strconv.Atoi(strings.TrimPrefix(v.Prerelease(), prefix)); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	return next, nil
+}
+
+// commitMessages returns hash and message of all commits in revRange including merges, newest first.
+// CalculateVersion and Changelog select the same commits through it.
+func commitMessages(ctx context.Context, revRange string) ([][2]string, error) {
+	output, err := gitOutput(ctx, "log", "--format=%H%x1f%B%x1e", revRange)
 	if err != nil {
-		return "", magelib.Fatal(err, "MostRecentTag")
+		return nil, errors.Wrap(err, "git [log]")
 	}
 
-	version := semver.Parse(tag)
+	messages := [][2]string{}
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 2)
+		if len(fields) != 2 {
+			continue
+		}
 
-	if version.VersionCore.Patch >= maxCount {
-		version.VersionCore.Patch = 0
-		version.BumpMinor()
-		return version.String(), nil
+		messages = append(messages, [2]string{fields[0], fields[1]})
 	}
 
-	if version.VersionCore.Minor >= maxCount {
-		version.VersionCore.Minor = 0
-		version.BumpMajor()
-		return version.String(), nil
+	return messages, nil
+}
+
+func tagPrefix(tag string) string {
+	if tag == "" || strings.HasPrefix(tag, "v") {
+		return "v"
+	}
+	return ""
+}
+
+// gitOutput runs git with args in the process working directory and returns its trimmed stdout.
+func gitOutput(ctx context.Context, args ...string) (string, error) {
+	return gitOutputIn(ctx, "", args...)
+}
+
+// gitOutputIn runs git with args in dir and returns its trimmed stdout.
+// An empty dir runs git in the process working directory.
+func gitOutputIn(ctx context.Context, dir string, args ...string) (string, error) {
+	out, err := gitRawOutputIn(ctx, dir, args...)
+	return strings.TrimSpace(out), err
+}
+
+// gitRawOutputIn runs git with args in dir and returns its stdout unmodified,
+// which keeps the whitespace of paths in NUL separated `-z` output.
+func gitRawOutputIn(ctx context.Context, dir string, args ...string) (string, error) {
+	var stderr = new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stderr = stderr
+	cmd.Dir = dir
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s err: [%s]", strings.Join(args, " "), stderr.String())
 	}
 
-	version.BumpPatch()
-	return version.String(), nil
+	return string(out), nil
 }
@@ -0,0 +1,121 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/denkhaus/magelib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConventionalCommit(t *testing.T) {
+	tests := []struct {
+		message  string
+		ok       bool
+		typ      string
+		scope    string
+		breaking bool
+		bump     Bump
+	}{
+		{"feat: add release workflow", true, "feat", "", false, BumpMinor},
+		{"fix(docker): handle buildkit output\n\nsome body", true, "fix", "docker", false, BumpPatch},
+		{"refactor(git)!: drop package globals", true, "refactor", "git", true, BumpMajor},
+		{"feat: new api\n\nBREAKING CHANGE: CommitAll returns the hash", true, "feat", "", true, BumpMajor},
+		{"chore: update deps", true, "chore", "", false, BumpNone},
+		{"Merge branch 'main'", false, "", "", false, BumpNone},
+	}
+
+	for _, test := range tests {
+		commit, ok := ParseConventionalCommit("abc", test.message)
+		if !assert.Equal(t, test.ok, ok, test.message) || !ok {
+			continue
+		}
+
+		assert.Equal(t, test.typ, commit.Type, test.message)
+		assert.Equal(t, test.scope, commit.Scope, test.message)
+		assert.Equal(t, test.breaking, commit.Breaking, test.message)
+		assert.Equal(t, test.bump, commit.Bump(), test.message)
+	}
+}
+
+// runGit runs a git command in dir with a fixed identity.
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+func TestCalculateVersion(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+
+	next := func(opts ...VersionOption) (info *VersionInfo, err error) {
+		err = magelib.InDirectory(dir, func() error {
+			info, err = CalculateVersion(context.Background(), opts...)
+			return err
+		})
+		return
+	}
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "initial commit")
+	_, err := next()
+	assert.Equal(t, ErrNoChanges, err)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "fix: first fix")
+	info, err := next()
+	assert.NoError(t, err)
+	assert.Equal(t, "", info.Previous)
+	assert.Equal(t, "v0.0.1", info.Next)
+
+	runGit(t, dir, "tag", "v1.2.3")
+	_, err = next()
+	assert.Equal(t, ErrNoChanges, err)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "fix: a bug")
+	info, err = next()
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.2.3", info.Previous)
+	assert.Equal(t, "v1.2.4", info.Next)
+	assert.Equal(t, BumpPatch, info.Bump)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat(git): a feature")
+	info, err = next(WithChannel("rc"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.0-rc.1", info.Next)
+	assert.Len(t, info.Commits, 2)
+
+	runGit(t, dir, "tag", "v1.3.0-rc.1")
+	runGit(t, dir, "tag", "v1.3.0-beta.4")
+	info, err = next(WithChannel("rc"), WithBuildMetadata("build.7"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.3.0-rc.2+build.7", info.Next)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "feat!: breaking", "-m", "details")
+	info, err = next()
+	assert.NoError(t, err)
+	assert.Equal(t, "v2.0.0", info.Next)
+	assert.Equal(t, BumpMajor, info.Bump)
+
+	runGit(t, dir, "tag", "2.0.0")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "docs: readme")
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "update readme")
+	_, err = next()
+	assert.Equal(t, ErrNoChanges, err)
+
+	runGit(t, dir, "commit", "-q", "--allow-empty", "-m", "perf: faster")
+	info, err = next()
+	assert.NoError(t, err)
+	assert.Equal(t, "2.0.0", info.Previous)
+	assert.Equal(t, "2.0.1", info.Next)
+	assert.Len(t, info.Commits, 2)
+}
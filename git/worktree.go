@@ -0,0 +1,142 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/pkg/errors"
+)
+
+// Worktree describes a working tree attached to a repository.
+type Worktree struct {
+	Path string
+	// Head is the commit checked out in the worktree.
+	Head string
+	// Branch is the checked out branch, empty if the worktree is detached or bare.
+	Branch   string
+	Detached bool
+	Bare     bool
+	Locked   bool
+	// Prunable is true if the worktree directory is gone and `git worktree prune` would remove it.
+	Prunable bool
+}
+
+// Worktrees lists the main worktree and all linked worktrees of the repository.
+func (r *LocalRepo) Worktrees() ([]Worktree, error) {
+	out, err := r.output("worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, errors.Wrap(err, "git [worktree list]")
+	}
+
+	worktrees := []Worktree{}
+	for _, block := range strings.Split(out, "\n\n") {
+		if block == "" {
+			continue
+		}
+
+		wt := Worktree{}
+		for _, line := range strings.Split(block, "\n") {
+			key, value := line, ""
+			if i := strings.IndexByte(line, ' '); i >= 0 {
+				key, value = line[:i], line[i+1:]
+			}
+
+			switch key {
+			case "worktree":
+				wt.Path = value
+			case "HEAD":
+				wt.Head = value
+			case "branch":
+				wt.Branch = strings.TrimPrefix(value, "refs/heads/")
+			case "detached":
+				wt.Detached = true
+			case "bare":
+				wt.Bare = true
+			case "locked":
+				wt.Locked = true
+			case "prunable":
+				wt.Prunable = true
+			}
+		}
+
+		worktrees = append(worktrees, wt)
+	}
+
+	return worktrees, nil
+}
+
+// AddWorktree checks out ref detached into a new temporary directory.
+// The caller is responsible for calling RemoveWorktree.
+//
+// Parameters:
+// - ref: the branch, tag or commit to check out.
+//
+// Returns:
+// - string: the path of the new worktree.
+// - error: an error if ref can't be checked out.
+func (r *LocalRepo) AddWorktree(ref string) (string, error) {
+	if ref == "" {
+		return "", ErrCommitNotDefined
+	}
+
+	dir, err := ioutil.TempDir("", "magelib-worktree-")
+	if err != nil {
+		return "", errors.Wrap(err, "TempDir")
+	}
+
+	if _, err := r.output("worktree", "add", "--detach", dir, ref); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "git [worktree add]")
+	}
+
+	logging.Infof("checked out [%s] in worktree [%s]", ref, dir)
+	return dir, nil
+}
+
+// RemoveWorktree removes the linked worktree at path including local modifications
+// and prunes its administrative files.
+func (r *LocalRepo) RemoveWorktree(path string) error {
+	if _, err := r.output("worktree", "remove", "--force", path); err != nil {
+		return errors.Wrap(err, "git [worktree remove]")
+	}
+
+	if _, err := r.output("worktree", "prune"); err != nil {
+		return errors.Wrap(err, "git [worktree prune]")
+	}
+
+	return nil
+}
+
+// InWorktreeCmd as magelib.Cmd
+func InWorktreeCmd(path, ref string, cmd magelib.Cmd) magelib.Cmd {
+	return func() error {
+		return Repo(path).InWorktree(ref, cmd)
+	}
+}
+
+// InWorktree runs cmd with ref checked out in a temporary worktree as working directory,
+// e.g. to build an old tag next to the current checkout. The worktree is always removed afterwards.
+//
+// Parameters:
+// - ref: the branch, tag or commit to check out.
+// - cmd: the command to run inside the worktree.
+//
+// Returns:
+// - error: the error of cmd, or an error if the worktree can't be created or removed.
+func (r *LocalRepo) InWorktree(ref string, cmd magelib.Cmd) (err error) {
+	dir, err := r.AddWorktree(ref)
+	if err != nil {
+		return errors.Wrap(err, "AddWorktree")
+	}
+
+	defer func() {
+		if rmErr := r.RemoveWorktree(dir); rmErr != nil && err == nil {
+			err = errors.Wrap(rmErr, "RemoveWorktree")
+		}
+	}()
+
+	return magelib.InDirectory(dir, cmd)
+}
@@ -0,0 +1,58 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInWorktree(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte("1"), 0644))
+	runGit(t, dir, "add", "VERSION")
+	runGit(t, dir, "commit", "-q", "-m", "version 1")
+	runGit(t, dir, "tag", "v1")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "VERSION"), []byte("2"), 0644))
+	runGit(t, dir, "commit", "-q", "-am", "version 2")
+
+	repo := Repo(dir)
+
+	var worktree string
+	err := repo.InWorktree("v1", func() error {
+		content, err := ioutil.ReadFile("VERSION")
+		require.NoError(t, err)
+		assert.Equal(t, "1", string(content))
+
+		worktrees, err := repo.Worktrees()
+		require.NoError(t, err)
+		require.Len(t, worktrees, 2)
+		assert.Equal(t, "main", worktrees[0].Branch)
+		assert.True(t, worktrees[1].Detached)
+
+		worktree = worktrees[1].Path
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NoDirExists(t, worktree)
+
+	// the worktree is removed if the command fails
+	err = repo.InWorktree("v1", func() error {
+		return errors.New("build failed")
+	})
+	assert.EqualError(t, err, "build failed")
+
+	worktrees, err := repo.Worktrees()
+	require.NoError(t, err)
+	assert.Len(t, worktrees, 1)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "VERSION"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(content))
+
+	assert.Error(t, repo.InWorktree("missing", func() error { return nil }))
+}
@@ -0,0 +1,174 @@
+package golang
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denkhaus/logging"
+	"github.com/denkhaus/magelib"
+	"github.com/denkhaus/magelib/git"
+	"github.com/magefile/mage/sh"
+	"github.com/pkg/errors"
+)
+
+// DevVersion is the version of builds from repositories without tags.
+const DevVersion = "dev"
+
+// BuildInfo holds the build metadata embedded into Go binaries with `-ldflags -X`.
+type BuildInfo struct {
+	Version string
+	Commit  string
+	Dirty   bool
+	Branch  string
+	Date    time.Time
+}
+
+// CollectBuildInfo collects the build metadata of the git repository at path.
+//
+// path: the path to the repository.
+// *BuildInfo: the most recent tag as version, the current commit, branch and dirtiness
+// and SOURCE_DATE_EPOCH as build date if set, otherwise the current time.
+// error: any error that occurred while querying the repository.
+func CollectBuildInfo(path string) (*BuildInfo, error) {
+	repo := git.Repo(path)
+	info := BuildInfo{}
+
+	commit, err := repo.CurrentCommit()
+	if err != nil {
+		return nil, magelib.Fatal(err, "CurrentCommit")
+	}
+	info.Commit = commit
+
+	info.Version, err = repo.MostRecentTag("HEAD")
+	if err != nil {
+		logging.Warnf("no tag found in repository [%s], use version [%s]", path, DevVersion)
+		info.Version = DevVersion
+	}
+
+	info.Branch, err = repo.Branch()
+	if err != nil {
+		return nil, magelib.Fatal(err, "Branch")
+	}
+
+	status, err := git.GitStatus(repo.Path())
+	if err != nil {
+		return nil, magelib.Fatal(err, "GitStatus")
+	}
+	info.Dirty = status.IsDirty() || status.IsModified()
+
+	info.Date, err = buildDate()
+	if err != nil {
+		return nil, magelib.Fatal(err, "buildDate")
+	}
+
+	return &info, nil
+}
+
+// LDFlags renders the metadata as `-X` flags for the variables Version, Commit, Dirty, Branch
+// and BuildDate of the target package, e.g. `github.com/org/app/version`.
+// The go command has no escapes in quoted flags, so values with a single quote are double quoted
+// and values containing both quotes are rejected.
+//
+// pkg: the import path of the package declaring the variables.
+// string: the flags to pass to `go build -ldflags`.
+// error: an error if a value can't be quoted.
+func (b *BuildInfo) LDFlags(pkg string) (string, error) {
+	vars := []struct {
+		name  string
+		value string
+	}{
+		{"Version", b.Version},
+		{"Commit", b.Commit},
+		{"Dirty", strconv.FormatBool(b.Dirty)},
+		{"Branch", b.Branch},
+		{"BuildDate", b.Date.UTC().Format(time.RFC3339)},
+	}
+
+	flags := make([]string, 0, len(vars))
+	for _, v := range vars {
+		flag, err := quoteFlag(fmt.Sprintf("%s.%s=%s", pkg, v.name, v.value))
+		if err != nil {
+			return "", errors.Wrapf(err, "quote %s", v.name)
+		}
+
+		flags = append(flags, "-X "+flag)
+	}
+
+	return strings.Join(flags, " "), nil
+}
+
+// quoteFlag quotes arg for the flag splitting of the go command, which doesn't support escapes.
+func quoteFlag(arg string) (string, error) {
+	switch {
+	case !strings.Contains(arg, "'"):
+		return "'" + arg + "'", nil
+	case !strings.Contains(arg, `"`):
+		return `"` + arg + `"`, nil
+	}
+
+	return "", errors.Errorf("%q contains both single and double quotes", arg)
+}
+
+// BuildCmd returns a command that builds the Go package at moduleDir with embedded build metadata.
+//
+// moduleDir: the directory of the main package.
+// output: the path of the binary to write.
+// pkg: the import path of the package receiving the metadata.
+// info: the build metadata. If nil, it is collected from moduleDir.
+//
+// magelib.Cmd: a command that builds the binary.
+func BuildCmd(moduleDir, output, pkg string, info *BuildInfo) magelib.Cmd {
+	return func() error {
+		return Build(moduleDir, output, pkg, info)
+	}
+}
+
+// Build builds the Go package at moduleDir with embedded build metadata.
+//
+// moduleDir: the directory of the main package.
+// output: the path of the binary to write.
+// pkg: the import path of the package receiving the metadata.
+// info: the build metadata. If nil, it is collected from moduleDir.
+//
+// error: an error if collecting the metadata or the build fails.
+func Build(moduleDir, output, pkg string, info *BuildInfo) error {
+	output, err := filepath.Abs(os.ExpandEnv(output))
+	if err != nil {
+		return magelib.Fatal(err, "Abs")
+	}
+
+	if info == nil {
+		if info, err = CollectBuildInfo(moduleDir); err != nil {
+			return magelib.Fatal(err, "CollectBuildInfo")
+		}
+	}
+
+	ldflags, err := info.LDFlags(pkg)
+	if err != nil {
+		return magelib.Fatal(err, "LDFlags")
+	}
+
+	return magelib.InDirectory(moduleDir, func() error {
+		logging.Infof("build [%s] version [%s] commit [%s]", output, info.Version, info.Commit)
+		return sh.RunV("go", "build", "-trimpath", "-ldflags", ldflags, "-o", output, ".")
+	})
+}
+
+// buildDate returns SOURCE_DATE_EPOCH if set, otherwise the current time.
+func buildDate() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now().UTC(), nil
+	}
+
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, magelib.Fatalf("invalid SOURCE_DATE_EPOCH %q", epoch)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
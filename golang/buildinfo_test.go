@@ -0,0 +1,105 @@
+package golang
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const versionMain = `package main
+
+import "fmt"
+
+var (
+	Version   string
+	Commit    string
+	Dirty     string
+	Branch    string
+	BuildDate string
+)
+
+func main() {
+	fmt.Println(Version, Commit[:7], Dirty, Branch, BuildDate)
+}
+`
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return string(out)
+}
+
+func TestLDFlags(t *testing.T) {
+	info := BuildInfo{
+		Version: "v1.2.3",
+		Commit:  "abc",
+		Dirty:   true,
+		Branch:  "main",
+		Date:    time.Unix(0, 0),
+	}
+
+	flags, err := info.LDFlags("example.com/app/version")
+	require.NoError(t, err)
+	assert.Equal(t,
+		"-X 'example.com/app/version.Version=v1.2.3' -X 'example.com/app/version.Commit=abc' "+
+			"-X 'example.com/app/version.Dirty=true' -X 'example.com/app/version.Branch=main' "+
+			"-X 'example.com/app/version.BuildDate=1970-01-01T00:00:00Z'",
+		flags,
+	)
+
+	info.Branch = "bob's fix"
+	flags, err = info.LDFlags("example.com/app/version")
+	require.NoError(t, err)
+	assert.Contains(t, flags, ` -X "example.com/app/version.Branch=bob's fix" `)
+
+	info.Branch = `bob's "fix"`
+	_, err = info.LDFlags("example.com/app/version")
+	assert.Error(t, err)
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(versionMain), 0644))
+
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "initial commit")
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+
+	info, err := CollectBuildInfo(dir)
+	require.NoError(t, err)
+	assert.Equal(t, DevVersion, info.Version)
+	assert.False(t, info.Dirty)
+
+	runGit(t, dir, "tag", "v1.0.0")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(versionMain+"\n"), 0644))
+
+	info, err = CollectBuildInfo(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0", info.Version)
+	assert.Equal(t, "main", info.Branch)
+	assert.True(t, info.Dirty)
+	assert.Equal(t, time.Unix(1600000000, 0).UTC(), info.Date)
+
+	output := filepath.Join(dir, "bin", "app")
+	require.NoError(t, Build(dir, output, "main", nil))
+
+	out, err := exec.Command(output).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0.0 "+info.Commit[:7]+" true main 2020-09-13T12:26:40Z\n", string(out))
+}
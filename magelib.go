@@ -38,7 +38,9 @@ func InDirectory(path string, cmd Cmd) (err error) {
 	}
 
 	defer func(p string) {
-		err = os.Chdir(p)
+		if chErr := os.Chdir(p); chErr != nil && err == nil {
+			err = errors.Wrap(chErr, "Chdir")
+		}
 	}(oldPath)
 
 	return cmd()
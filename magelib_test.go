@@ -5,6 +5,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/pkg/errors"
+
 	"gopkg.in/src-d/go-git.v4"
 
 	"github.com/stretchr/testify/suite"
@@ -47,6 +49,25 @@ func (suite *magelibTest) TestGitCommit() {
 	fmt.Println(commit.String())
 }
 
+func (suite *magelibTest) TestInDirectoryReturnsCmdError() {
+	wd, err := os.Getwd()
+	suite.Require().NoError(err)
+
+	dir := suite.T().TempDir()
+	cmdErr := errors.New("failed")
+	err = InDirectory(dir, func() error {
+		cwd, err := os.Getwd()
+		suite.Require().NoError(err)
+		suite.Equal(dir, cwd)
+		return cmdErr
+	})
+	suite.Equal(cmdErr, err)
+
+	cwd, err := os.Getwd()
+	suite.Require().NoError(err)
+	suite.Equal(wd, cwd)
+}
+
 func TestCommon(t *testing.T) {
 	testSuite := new(magelibTest)
 	suite.Run(t, testSuite)
@@ -38,12 +38,15 @@ func YesNo(label string) (bool, error) {
 		IsConfirm: true,
 	}
 
-	result, err := prompt.Run()
-	if err != nil {
+	// promptui returns ErrAbort for any answer other than y or Y
+	if _, err := prompt.Run(); err != nil {
+		if err == promptui.ErrAbort {
+			return false, nil
+		}
 		return false, errors.Wrap(err, "prompt failed")
 	}
 
-	return result == "yes", nil
+	return true, nil
 }
 
 // Select displays a prompt with a given label and a list of choices, and returns the selected choice.
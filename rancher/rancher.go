@@ -1,3 +1,6 @@
+// Package rancher installs and runs the Rancher 1.x CLI and rancher-compose.
+//
+// Deprecated: Rancher 1.x is end of life, use github.com/denkhaus/magelib/docker/compose for compose projects.
 package rancher
 
 import (